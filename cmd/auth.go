@@ -0,0 +1,50 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	email "github.com/duanechan/monitoring-utils/email/internal"
+)
+
+// Handles "auth": authorizes the SMTP account with OAuth2 once and
+// stores its refresh token for later sends.
+func auth(args []string) error {
+	fs := flag.NewFlagSet("auth", flag.ExitOnError)
+	device := fs.Bool("device", false, "enter a code on another device instead of opening a browser here")
+	fs.Parse(args)
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	if config.Auth != email.AuthXOAUTH2 {
+		return fmt.Errorf("SMTP_AUTH must be set to %s to authorize with OAuth2", email.AuthXOAUTH2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *device {
+		err = config.OAuth.AuthorizeDevice(ctx, func(url, code string) {
+			fmt.Printf("Go to %s and enter the code %s\n", url, code)
+		})
+	} else {
+		err = config.OAuth.AuthorizeLoopback(ctx, func(url string) {
+			fmt.Printf("Opening your browser to authorize %s. If it doesn't open, go to:\n\n%s\n\n", config.SMTPUser, url)
+			email.OpenInBrowser(url)
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Authorized. The refresh token is stored in %s\n", config.OAuth.TokenFile)
+	return nil
+}
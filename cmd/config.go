@@ -0,0 +1,106 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	email "github.com/duanechan/monitoring-utils/email/internal"
+)
+
+// Handles "config set-password" and "config doctor".
+func configure(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: config set-password | config doctor")
+	}
+
+	switch args[0] {
+	case "set-password":
+		return setPassword(args[1:])
+	case "doctor":
+		return doctor()
+	}
+	return fmt.Errorf("unknown config command %q", args[0])
+}
+
+// Asks for a secret and writes it to the secret store.
+func setPassword(args []string) error {
+	fs := flag.NewFlagSet("config set-password", flag.ExitOnError)
+	backend := fs.String("backend", "", "where to store it: env, vault or keyring (default SECRETS_BACKEND, or env)")
+	key := fs.String("key", "SMTP_PASS", "the secret to set, e.g. OAUTH_CLIENT_SECRET")
+	fs.Parse(args)
+
+	// SECRETS_BACKEND and the vault settings may be in .env
+	_ = godotenv.Load()
+
+	store, err := email.OpenSecretStore(*backend)
+	if err != nil {
+		return err
+	}
+
+	secret, err := email.ReadSecret(*key + ": ")
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		return fmt.Errorf("no %s given", *key)
+	}
+
+	if err := store.Set(*key, secret); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s saved.\n", *key)
+	return nil
+}
+
+// Prints a pass/fail checklist of the config and the SMTP server.
+func doctor() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	failed := 0
+	for _, check := range email.Doctor(ctx) {
+		mark, detail := "PASS", check.Detail
+		switch {
+		case check.Err != nil:
+			mark, detail = "FAIL", check.Err.Error()
+			failed++
+		case check.Warn:
+			mark = "WARN"
+		}
+
+		if detail != "" {
+			detail = ": " + detail
+		}
+		fmt.Printf("[%s] %s%s\n", mark, check.Name, detail)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("\n%d check(s) failed", failed)
+	}
+
+	fmt.Println("\nAll checks passed.")
+	return nil
+}
+
+// Loads the config, with the -var variables, and prints its warnings.
+func loadConfig() (email.EmailConfig, error) {
+	config, err := email.LoadConfig()
+	if err != nil {
+		return config, fmt.Errorf("error loading config: %w", err)
+	}
+	config.Vars = batchVars
+
+	for _, warning := range config.Warnings {
+		fmt.Fprintln(os.Stderr, "warning:", warning)
+	}
+
+	return config, nil
+}
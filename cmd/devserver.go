@@ -0,0 +1,41 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+
+	email "github.com/duanechan/monitoring-utils/email/internal"
+)
+
+// Handles "devserver": runs a local SMTP server that catches every
+// email sent to it and shows them in a web inbox.
+func devserver(args []string) error {
+	fs := flag.NewFlagSet("devserver", flag.ExitOnError)
+	smtpAddr := fs.String("smtp", "localhost:2525", "the address of the SMTP server")
+	httpAddr := fs.String("http", "localhost:8025", "the address of the web inbox")
+	dir := fs.String("dir", "", "also save the emails as .eml files in this folder")
+	fs.Parse(args)
+
+	host, port, err := net.SplitHostPort(*smtpAddr)
+	if err != nil {
+		return fmt.Errorf("invalid -smtp address: %w", err)
+	}
+	if host == "" {
+		host = "localhost"
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Printf("Catching emails on %s, see them at http://%s\n", *smtpAddr, *httpAddr)
+	fmt.Printf("Send to it with SMTP_HOST=%s SMTP_PORT=%s and any SMTP_USER and SMTP_PASS. Press Ctrl+C to stop.\n", host, port)
+
+	server := &email.DevServer{Dir: *dir}
+	return server.Run(ctx, *smtpAddr, *httpAddr)
+}
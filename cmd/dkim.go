@@ -0,0 +1,43 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package main
+
+import (
+	"fmt"
+)
+
+// Handles "dkim check": prints the DNS record to publish for the DKIM
+// key and whether it's published yet.
+func dkim(args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return fmt.Errorf("usage: dkim check")
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	if config.DKIM == nil {
+		return fmt.Errorf("DKIM_PRIVATE_KEY and DKIM_SELECTOR must be set to sign with DKIM")
+	}
+
+	name, record, err := config.DKIM.Record()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Publish this TXT record in your domain's DNS:")
+	fmt.Println()
+	fmt.Printf("  Name:  %s\n", name)
+	fmt.Printf("  Value: %s\n", record)
+	fmt.Println()
+
+	if err := config.DKIM.CheckRecord(); err != nil {
+		fmt.Println("Not published yet:", err)
+		return nil
+	}
+
+	fmt.Println("It's published and matches the key.")
+	return nil
+}
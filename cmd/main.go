@@ -6,24 +6,150 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 	email "github.com/duanechan/monitoring-utils/email/internal"
 	"github.com/duanechan/monitoring-utils/email/internal/model"
 )
 
+// Subcommands that run without the TUI.
+var commands = map[string]func(args []string) error{
+	"schedule":      schedule,
+	"run-scheduler": runScheduler,
+	"resume":        resume,
+	"auth":          auth,
+	"config":        configure,
+	"devserver":     devserver,
+	"dkim":          dkim,
+	"serve-secrets": serveSecrets,
+	"templates":     templates,
+}
+
+// Flags that override a setting of the config file, .env or environment.
+var settingFlags = map[string]struct{ setting, usage string }{
+	"smtp-host":    {"SMTP_HOST", "the SMTP server"},
+	"smtp-port":    {"SMTP_PORT", "the SMTP server's port"},
+	"sender-name":  {"SENDER_NAME", "the name emails are sent from"},
+	"sender-email": {"SENDER_EMAIL", "the address emails are sent from"},
+	"cc":           {"CC_EMAIL", "the addresses to CC on every email"},
+	"bcc":          {"BCC_EMAIL", "the addresses to BCC on every email"},
+	"templates":    {"TEMPLATES", "the templates that can be sent, e.g. CRED,LATE"},
+	"timezone":     {"TIMEZONE", "the time zone of dates in templates, e.g. Asia/Manila"},
+	"locale":       {"DEFAULT_LOCALE", "the locale of recipients without a language, e.g. fil"},
+}
+
+// Variables of the batch set with -var, for templates.
+var batchVars = varsFlag{}
+
+// Collects repeated -var name=value flags.
+type varsFlag map[string]string
+
+func (v varsFlag) String() string {
+	vars := []string{}
+	for name, value := range v {
+		vars = append(vars, name+"="+value)
+	}
+	return strings.Join(vars, ",")
+}
+
+func (v varsFlag) Set(s string) error {
+	name, value, err := email.ParseVar(s)
+	if err != nil {
+		return err
+	}
+	v[name] = value
+	return nil
+}
+
 func main() {
+	configFile := flag.String("config", "", "the YAML or TOML config file (default config.yaml, config.yml or config.toml)")
+	profile := flag.String("profile", "", "the profile of the config file to use, e.g. ph-monitoring")
+	for name, f := range settingFlags {
+		flag.String(name, "", f.usage)
+	}
+
 	rName := flag.String("name", "", "the name of the recipient")
 	rEmail := flag.String("email", "", "the email of the recipient")
+	rLanguage := flag.String("language", "", "the language of the recipient with -test-to, e.g. fil or zh")
+	testTo := flag.String("test-to", "", "send a [TEST] copy of the email to this address only")
+	tmpl := flag.String("template", "CRED", "the template to send with -test-to (CRED or LATE)")
+	flag.Var(batchVars, "var", "a variable for the templates, e.g. -var startDate=2026-11-03; repeat for more")
 	flag.Parse()
 
-	config, err := email.LoadConfig()
+	overrides := map[string]string{}
+	flag.Visit(func(f *flag.Flag) {
+		if setting, ok := settingFlags[f.Name]; ok {
+			overrides[setting.setting] = f.Value.String()
+		}
+	})
+
+	if err := email.LoadSettings(*configFile, *profile, overrides); err != nil {
+		fmt.Printf("error loading settings: %s\n", err)
+		os.Exit(1)
+	}
+
+	closeLog, err := email.SetupLogging()
 	if err != nil {
-		fmt.Printf("error loading config: %s\n", err)
+		fmt.Printf("error setting up logging: %s\n", err)
 		os.Exit(1)
 	}
+	defer closeLog()
+
+	if flag.NArg() > 0 {
+		command, ok := commands[flag.Arg(0)]
+		if !ok {
+			fmt.Printf("unknown command %q\n", flag.Arg(0))
+			os.Exit(1)
+		}
+		if err := command(flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	transport, err := email.NewTransport(config)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *testTo != "" && *rName != "" && *rEmail != "" {
+		template, err := config.Template(*tmpl)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		language, err := email.ParseLocale(*rLanguage)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		em := email.Email{
+			Body:   template,
+			To:     email.User{Name: *rName, Email: *rEmail, Language: language},
+			Config: config,
+		}
+
+		if err := em.SendTest(transport, email.User{Email: *testTo}); err != nil {
+			fmt.Printf("error sending test email: %s\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Test email sent to %s\n", *testTo)
+		return
+	}
 
-	p := tea.NewProgram(model.InitializeModel(*rName, *rEmail, config))
+	p := tea.NewProgram(model.InitializeModel(*rName, *rEmail, email.User{Email: *testTo}, config, transport))
 	if _, err := p.Run(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
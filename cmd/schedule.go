@@ -0,0 +1,189 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	email "github.com/duanechan/monitoring-utils/email/internal"
+)
+
+// Handles "schedule", "schedule list" and "schedule cancel <id>".
+func schedule(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "list":
+			return listJobs()
+		case "cancel":
+			if len(args) < 2 {
+				return fmt.Errorf("usage: schedule cancel <job id>")
+			}
+			if err := email.CancelJob(args[1]); err != nil {
+				return err
+			}
+			fmt.Printf("Cancelled job %s\n", args[1])
+			return nil
+		}
+	}
+
+	fs := flag.NewFlagSet("schedule", flag.ExitOnError)
+	at := fs.String("at", "", `when to send the emails, e.g. "2026-11-03 07:30"`)
+	file := fs.String("file", "", "the CSV or XLSX file of recipients")
+	tmpl := fs.String("template", "CRED", "the template to send (CRED or LATE)")
+	force := fs.Bool("force", false, "queue the emails even if the send time has passed, so they're sent at once")
+	fs.Var(batchVars, "var", "a variable for the templates, e.g. -var startDate=2026-11-03; repeat for more")
+	fs.Parse(args)
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	sendAt, err := parseTime(*at, config.Location, *force)
+	if err != nil {
+		return err
+	}
+
+	records, err := email.ParseData(strings.ReplaceAll(*file, "\"", ""))
+	if err != nil {
+		return err
+	}
+
+	result := email.ValidateRecords(records)
+	for _, bad := range result.BadEmails {
+		fmt.Println("Skipped:", bad)
+	}
+
+	job, err := email.ScheduleBatch(*tmpl, result.Recipients, config, sendAt)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Scheduled job %s: %d emails at %s\n", job.ID, len(job.Messages), job.SendAt.Format(time.DateTime+" MST"))
+	return nil
+}
+
+func listJobs() error {
+	jobs, err := email.ListJobs()
+	if err != nil {
+		return err
+	}
+
+	if len(jobs) == 0 {
+		fmt.Println("No queued jobs.")
+		return nil
+	}
+
+	for _, job := range jobs {
+		sent, failed := job.Count()
+		status := fmt.Sprintf("%d sent, %d failed", sent, failed)
+		switch {
+		case job.Done():
+			if cancelled := len(job.Messages) - sent - failed; cancelled > 0 {
+				status += fmt.Sprintf(", %d cancelled", cancelled)
+			}
+		case job.Scheduled && sent+failed == 0:
+			status = "scheduled"
+		default:
+			status = "interrupted, " + status
+		}
+		fmt.Printf("%-22s %-5s %s  %3d emails  %s\n",
+			job.ID, job.Template, job.SendAt.Format(time.DateTime), len(job.Messages), status)
+	}
+
+	return nil
+}
+
+// Handles "run-scheduler".
+func runScheduler(args []string) error {
+	fs := flag.NewFlagSet("run-scheduler", flag.ExitOnError)
+	interval := fs.Duration("interval", 30*time.Second, "how often to check for due jobs")
+	once := fs.Bool("once", false, "send the jobs that are due and exit")
+	fs.Parse(args)
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	transport, err := email.NewTransport(config)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *once {
+		return stopped(ctx, email.RunDueJobs(ctx, transport, time.Now(), printResult))
+	}
+
+	fmt.Printf("Scheduler running, checking %s every %s. Press ctrl+c to stop.\n", email.QueueDir(), *interval)
+	return email.RunScheduler(ctx, transport, *interval, printResult)
+}
+
+// Handles "resume [job id]".
+func resume(args []string) error {
+	id := ""
+	if len(args) > 0 {
+		id = args[0]
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	transport, err := email.NewTransport(config)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	return stopped(ctx, email.ResumeJobs(ctx, transport, id, printResult))
+}
+
+// Replaces the error of a run stopped with ctrl+c by a hint to resume it.
+func stopped(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("stopped, run \"resume\" to send the rest")
+	}
+	return err
+}
+
+func printResult(job email.Job, m email.QueuedMessage) {
+	if m.State == email.Sent {
+		fmt.Printf("[%s] ✔ Sent: %s\n", job.ID, m.To.Email)
+	} else {
+		fmt.Printf("[%s] ✖ Failed: %s (%s)\n", job.ID, m.To.Email, m.Reason())
+	}
+}
+
+// Parses the send time in the time zone of TIMEZONE. Times that have
+// passed are refused unless force is set.
+func parseTime(value string, loc *time.Location, force bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("no send time provided, use -at")
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04", time.DateTime, time.RFC3339} {
+		t, err := time.ParseInLocation(layout, value, loc)
+		if err != nil {
+			continue
+		}
+		if !force && t.Before(time.Now()) {
+			return time.Time{}, fmt.Errorf("send time %s has already passed, use -force to send the emails at once", t.Format(time.DateTime+" MST"))
+		}
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid send time %q, expected YYYY-MM-DD HH:MM", value)
+}
@@ -0,0 +1,35 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	email "github.com/duanechan/monitoring-utils/email/internal"
+)
+
+// Handles "serve-secrets": serves the one-time password links put in
+// Credentials emails.
+func serveSecrets(args []string) error {
+	fs := flag.NewFlagSet("serve-secrets", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8090", "the address to listen on, behind the SECRET_LINKS_URL proxy")
+	fs.Parse(args)
+
+	links, err := email.LoadSecretLinks()
+	if err != nil {
+		return err
+	}
+	if links == nil {
+		return fmt.Errorf("SECRET_LINKS_URL must be set to serve password links")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Printf("Serving password links from %s on %s for %s. Press Ctrl+C to stop.\n", links.Dir, *addr, links.BaseURL)
+	return links.Serve(ctx, *addr)
+}
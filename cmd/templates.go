@@ -0,0 +1,80 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+
+	email "github.com/duanechan/monitoring-utils/email/internal"
+)
+
+// Handles "templates lint": checks the templates and fails if any has
+// an error, so template changes can be checked before they're merged.
+func templates(args []string) error {
+	if len(args) == 0 || args[0] != "lint" {
+		return fmt.Errorf("usage: templates lint [-file recipients.csv] [-var name=value] [-strict] [template...]")
+	}
+
+	fs := flag.NewFlagSet("templates lint", flag.ExitOnError)
+	file := fs.String("file", "", "check the columns the templates use against this CSV or XLSX file")
+	strict := fs.Bool("strict", false, "fail on warnings too")
+	fs.Var(batchVars, "var", "a variable for the templates, e.g. -var startDate=2026-11-03; repeat for more")
+	fs.Parse(args[1:])
+
+	selected := []email.Template{}
+	for _, t := range email.AllTemplates() {
+		if fs.NArg() == 0 || slices.ContainsFunc(fs.Args(), func(name string) bool { return strings.EqualFold(name, t.Name) }) {
+			selected = append(selected, t)
+		}
+	}
+	if len(selected) == 0 {
+		return fmt.Errorf("no template named %s", strings.Join(fs.Args(), ", "))
+	}
+
+	// Variables and columns are only checked when they're known
+	var vars map[string]string
+	if len(batchVars) > 0 {
+		vars = batchVars
+	}
+
+	var columns []string
+	if *file != "" {
+		records, err := email.ParseData(strings.ReplaceAll(*file, "\"", ""))
+		if err != nil {
+			return err
+		}
+		columns = email.ValidateRecords(records).FieldNames()
+	}
+
+	errors, warnings := 0, 0
+	for _, problem := range email.LintTemplates(selected, vars, columns) {
+		level := "error"
+		if problem.Warn {
+			level = "warning"
+			warnings++
+		} else {
+			errors++
+		}
+
+		if problem.Template == "" {
+			fmt.Printf("%s: %s\n", level, problem.Message)
+		} else {
+			fmt.Printf("%s: %s: %s\n", problem.Location(), level, problem.Message)
+		}
+	}
+
+	if errors == 0 && warnings == 0 {
+		fmt.Printf("%d template(s) checked, no problems found.\n", len(selected))
+		return nil
+	}
+
+	summary := fmt.Sprintf("\n%d error(s), %d warning(s)", errors, warnings)
+	if errors > 0 || *strict {
+		return fmt.Errorf("%s", summary)
+	}
+	fmt.Println(summary)
+	return nil
+}
@@ -0,0 +1,384 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	netmail "net/mail"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Settings of the HTTP API transports.
+type APIConfig struct {
+	BaseURL string // API_BASE_URL, e.g. a local stand-in for testing
+	Key     string // API key, or the AWS access key ID for SES
+	Secret  string // AWS secret access key, SES only
+	Token   string // AWS session token, SES only
+	Region  string // AWS region, SES only
+	Domain  string // Sending domain, Mailgun only
+	Stream  string // Message stream, Postmark only
+}
+
+// Where each provider's API is, unless API_BASE_URL is set.
+var apiBaseURLs = map[string]string{
+	TransportSendGrid: "https://api.sendgrid.com",
+	TransportMailgun:  "https://api.mailgun.net",
+	TransportSES:      "https://email.%s.amazonaws.com",
+	TransportPostmark: "https://api.postmarkapp.com",
+}
+
+func loadAPIConfig(transport string, from User, secrets SecretStore) (APIConfig, error) {
+	config := APIConfig{
+		Region: getenv("AWS_REGION", "us-east-1"),
+		Stream: getenv("POSTMARK_MESSAGE_STREAM", "outbound"),
+	}
+
+	var err error
+	if config.Key, err = secretOr(secrets, "API_KEY", "AWS_ACCESS_KEY_ID", transport == TransportSES); err != nil {
+		return APIConfig{}, err
+	}
+	if config.Key == "" {
+		return APIConfig{}, fmt.Errorf("API_KEY must be set to send through %s", transport)
+	}
+
+	base := apiBaseURLs[transport]
+	switch transport {
+	case TransportSES:
+		if config.Secret, err = secretOr(secrets, "API_SECRET", "AWS_SECRET_ACCESS_KEY", true); err != nil {
+			return APIConfig{}, err
+		}
+		if config.Secret == "" {
+			return APIConfig{}, fmt.Errorf("API_SECRET must be set to send through %s", transport)
+		}
+		config.Token = os.Getenv("AWS_SESSION_TOKEN")
+		base = fmt.Sprintf(base, config.Region)
+	case TransportMailgun:
+		_, domain, _ := strings.Cut(from.Email, "@")
+		config.Domain = getenv("MAILGUN_DOMAIN", domain)
+	}
+
+	config.BaseURL = strings.TrimRight(getenv("API_BASE_URL", base), "/")
+	return config, nil
+}
+
+// Returns the secret key, or fallback if key isn't set and useFallback
+// is true.
+func secretOr(secrets SecretStore, key, fallback string, useFallback bool) (string, error) {
+	value, err := secrets.Get(key)
+	if err != nil || value != "" || !useFallback {
+		return value, err
+	}
+	return secrets.Get(fallback)
+}
+
+// Kinds of failures reported by the HTTP APIs, to be matched with
+// errors.Is.
+var (
+	ErrRecipientRejected = errors.New("recipient rejected")
+	ErrUnauthorized      = errors.New("not authorized")
+	ErrRateLimited       = errors.New("rate limited")
+	ErrRejected          = errors.New("message rejected")
+	ErrProvider          = errors.New("provider error")
+)
+
+// A failure reported by an HTTP API. When the provider names the
+// recipients it refused, there's one SendError for each of them.
+type SendError struct {
+	Provider  string
+	Status    int    // HTTP status
+	Code      string // The provider's own error code, if any
+	Message   string
+	Recipient string // The refused recipient, if the error is about one
+	Kind      error  // One of the Err* kinds above
+}
+
+func (e *SendError) Error() string {
+	s := fmt.Sprintf("%s: %v", e.Provider, e.Kind)
+	if e.Recipient != "" {
+		s += " " + e.Recipient
+	}
+
+	s += fmt.Sprintf(" (HTTP %d", e.Status)
+	if e.Code != "" {
+		s += ", " + e.Code
+	}
+	return s + "): " + e.Message
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Kind
+}
+
+// Returns the error, split into one SendError for each rejected
+// recipient if there are any.
+func newSendError(provider string, status int, code, message string, kind error, rejected []string) error {
+	if len(rejected) == 0 {
+		return &SendError{Provider: provider, Status: status, Code: code, Message: message, Kind: kind}
+	}
+
+	errs := make([]error, len(rejected))
+	for i, rcpt := range rejected {
+		errs[i] = &SendError{Provider: provider, Status: status, Code: code, Message: message, Recipient: rcpt, Kind: ErrRecipientRejected}
+	}
+	return errors.Join(errs...)
+}
+
+// A recipient that a provider refused.
+type Rejection struct {
+	Recipient string `json:"recipient"`
+	Code      string `json:"code"` // The provider's error code, or else the HTTP status
+	Message   string `json:"message,omitempty"`
+}
+
+func (r Rejection) String() string {
+	return fmt.Sprintf("%s (%s)", r.Recipient, r.Code)
+}
+
+// Returns the recipients that the provider refused in err, if any.
+func Rejections(err error) []Rejection {
+	rejected := []Rejection{}
+
+	var walk func(error)
+	walk = func(err error) {
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, err := range joined.Unwrap() {
+				walk(err)
+			}
+			return
+		}
+
+		var sendErr *SendError
+		if errors.As(err, &sendErr) && sendErr.Recipient != "" {
+			rejected = append(rejected, Rejection{
+				Recipient: sendErr.Recipient,
+				Code:      cmp.Or(sendErr.Code, strconv.Itoa(sendErr.Status)),
+				Message:   sendErr.Message,
+			})
+		}
+	}
+	walk(err)
+
+	return rejected
+}
+
+// Returns the kind of failure that an HTTP status means.
+func statusKind(status int) error {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrUnauthorized
+	case status == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case status >= 500:
+		return ErrProvider
+	}
+	return ErrRejected
+}
+
+// Returns the recipients mentioned in a provider's error message, for
+// the providers that only name refused addresses in prose.
+func recipientsIn(message string, rcpts []string) []string {
+	found := []string{}
+	lower := strings.ToLower(message)
+	for _, rcpt := range rcpts {
+		if strings.Contains(lower, strings.ToLower(rcpt)) {
+			found = append(found, rcpt)
+		}
+	}
+	return found
+}
+
+var apiClient = &http.Client{Timeout: 30 * time.Second}
+
+// Sends the request and returns the response's status, headers and body.
+func doRequest(req *http.Request) (int, http.Header, []byte, error) {
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	return resp.StatusCode, resp.Header, body, nil
+}
+
+// A rendered message taken apart again, for the APIs that want its
+// parts as JSON rather than the message itself.
+type parsedMessage struct {
+	From        *netmail.Address
+	To          []*netmail.Address
+	Cc          []*netmail.Address
+	Bcc         []*netmail.Address // Recipients not in To or Cc
+	ReplyTo     []*netmail.Address
+	Subject     string
+	Headers     map[string]string // Headers the APIs don't have a field for
+	Text        string
+	HTML        string
+	Attachments []apiAttachment
+}
+
+type apiAttachment struct {
+	Name        string
+	ContentType string
+	ContentID   string // Set for inline images
+	Data        []byte
+}
+
+// Headers that are set through the APIs' own fields, or by the providers.
+var reservedHeaders = map[string]bool{
+	"From": true, "To": true, "Cc": true, "Bcc": true, "Reply-To": true,
+	"Subject": true, "Date": true, "Message-Id": true, "Mime-Version": true,
+	"Content-Type": true, "Content-Transfer-Encoding": true,
+	"User-Agent": true, "X-Mailer": true, "Dkim-Signature": true,
+}
+
+func parseMessage(raw RawMessage) (parsedMessage, error) {
+	msg, err := netmail.ReadMessage(bytes.NewReader(raw.Data))
+	if err != nil {
+		return parsedMessage{}, fmt.Errorf("failed to parse the message: %w", err)
+	}
+
+	decoder := new(mime.WordDecoder)
+	parsed := parsedMessage{Headers: map[string]string{}}
+
+	if parsed.Subject, err = decoder.DecodeHeader(msg.Header.Get("Subject")); err != nil {
+		return parsedMessage{}, fmt.Errorf("invalid subject: %w", err)
+	}
+
+	from, err := msg.Header.AddressList("From")
+	if err != nil || len(from) == 0 {
+		return parsedMessage{}, fmt.Errorf("invalid From header: %w", err)
+	}
+	parsed.From = from[0]
+
+	for _, list := range []struct {
+		header string
+		into   *[]*netmail.Address
+	}{{"To", &parsed.To}, {"Cc", &parsed.Cc}, {"Reply-To", &parsed.ReplyTo}} {
+		addrs, err := msg.Header.AddressList(list.header)
+		if err != nil && !errors.Is(err, netmail.ErrHeaderNotPresent) {
+			return parsedMessage{}, fmt.Errorf("invalid %s header: %w", list.header, err)
+		}
+		*list.into = addrs
+	}
+
+	// Blind copies aren't in the headers, only in the envelope
+	visible := map[string]bool{}
+	for _, addr := range append(parsed.To, parsed.Cc...) {
+		visible[strings.ToLower(addr.Address)] = true
+	}
+	for _, rcpt := range raw.Rcpts {
+		if !visible[strings.ToLower(rcpt)] {
+			parsed.Bcc = append(parsed.Bcc, &netmail.Address{Address: rcpt})
+		}
+	}
+
+	for key, values := range msg.Header {
+		if reservedHeaders[key] || len(values) == 0 {
+			continue
+		}
+		if parsed.Headers[key], err = decoder.DecodeHeader(values[0]); err != nil {
+			parsed.Headers[key] = values[0]
+		}
+	}
+
+	if err := parsed.walk(textproto.MIMEHeader(msg.Header), msg.Body); err != nil {
+		return parsedMessage{}, fmt.Errorf("failed to parse the message: %w", err)
+	}
+
+	return parsed, nil
+}
+
+// Collects the bodies and attachments of a part and the parts in it.
+func (p *parsedMessage) walk(header textproto.MIMEHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := p.walk(part.Header, part); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	disposition, dispParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	contentID := strings.Trim(header.Get("Content-Id"), "<>")
+
+	switch {
+	case disposition == "" && contentID == "" && mediaType == "text/plain" && p.Text == "":
+		p.Text = string(data)
+	case disposition == "" && contentID == "" && mediaType == "text/html" && p.HTML == "":
+		p.HTML = string(data)
+	default:
+		name := dispParams["filename"]
+		if name == "" {
+			name = params["name"]
+		}
+		p.Attachments = append(p.Attachments, apiAttachment{
+			Name:        name,
+			ContentType: mediaType,
+			ContentID:   contentID,
+			Data:        data,
+		})
+	}
+
+	return nil
+}
+
+// Returns a POST of the value as JSON.
+func newJSONRequest(ctx context.Context, url string, value any) (*http.Request, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	return req, nil
+}
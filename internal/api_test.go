@@ -0,0 +1,451 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns the Late email to Jane, with a CC and a BCC, as it's sent.
+func testRawMessage(t *testing.T) RawMessage {
+	t.Helper()
+
+	em := Email{
+		Body: Late,
+		To: User{
+			Name:  "Jane Doe",
+			Email: "jane@example.com",
+			CC:    []User{{Email: "boss@example.com"}},
+			BCC:   []User{{Email: "audit@example.com"}},
+		},
+		Config: EmailConfig{From: User{Name: "Monitoring", Email: "monitoring@example.com"}},
+	}
+
+	message, err := em.Message()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := render("LATE", message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// A request the stand-in API received.
+type apiRequest struct {
+	method string
+	path   string
+	header http.Header
+	host   string
+	body   []byte
+}
+
+// Starts a stand-in API that records each request and answers it with
+// the handler.
+func standIn(t *testing.T, respond func(w http.ResponseWriter, r apiRequest)) (*httptest.Server, *[]apiRequest) {
+	t.Helper()
+
+	requests := []apiRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req := apiRequest{method: r.Method, path: r.URL.Path, header: r.Header.Clone(), host: r.Host, body: body}
+		requests = append(requests, req)
+		respond(w, req)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests
+}
+
+func reply(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	io.WriteString(w, body)
+}
+
+// Checks that err is a SendError of the kind, and that the rejected
+// recipients are the ones wanted.
+func checkSendError(t *testing.T, err error, kind error, rejected ...Rejection) {
+	t.Helper()
+
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) {
+		t.Fatalf("error %v isn't a SendError", err)
+	}
+	if !errors.Is(err, kind) {
+		t.Errorf("error %v isn't %v", err, kind)
+	}
+
+	got := Rejections(err)
+	for i := range got {
+		got[i].Message = ""
+	}
+	if !slices.Equal(got, append([]Rejection{}, rejected...)) {
+		t.Errorf("rejected %v, want %v", got, rejected)
+	}
+}
+
+func TestSendGridTransport(t *testing.T) {
+	raw := testRawMessage(t)
+
+	server, requests := standIn(t, func(w http.ResponseWriter, r apiRequest) {
+		w.Header().Set("X-Message-Id", "sg-123")
+		w.WriteHeader(http.StatusAccepted)
+	})
+	transport := SendGridTransport{API: APIConfig{BaseURL: server.URL, Key: "sg-key"}}
+
+	response, err := transport.Send(context.Background(), raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(response, "message id sg-123") {
+		t.Errorf("response = %q, want the message id sg-123", response)
+	}
+
+	req := (*requests)[0]
+	if req.method != http.MethodPost || req.path != "/v3/mail/send" {
+		t.Errorf("request = %s %s, want POST /v3/mail/send", req.method, req.path)
+	}
+	if auth := req.header.Get("Authorization"); auth != "Bearer sg-key" {
+		t.Errorf("Authorization = %q, want Bearer sg-key", auth)
+	}
+
+	var body sendGridMail
+	if err := json.Unmarshal(req.body, &body); err != nil {
+		t.Fatal(err)
+	}
+	p := body.Personalizations[0]
+	if body.From.Email != "monitoring@example.com" || body.Subject != "Important Reminder for Late Interns" ||
+		len(p.To) != 1 || p.To[0].Email != "jane@example.com" ||
+		len(p.Cc) != 1 || p.Cc[0].Email != "boss@example.com" ||
+		len(p.Bcc) != 1 || p.Bcc[0].Email != "audit@example.com" {
+		t.Errorf("unexpected body: %s", req.body)
+	}
+	if !slices.ContainsFunc(body.Content, func(c sendGridContent) bool {
+		return c.Type == "text/html" && strings.Contains(c.Value, "Late Interns")
+	}) {
+		t.Error("the HTML body wasn't sent")
+	}
+	if !slices.ContainsFunc(body.Attachments, func(a sendGridAttachment) bool { return a.ContentID == "logo" && a.Disposition == "inline" }) {
+		t.Error("the logo wasn't sent inline")
+	}
+
+	for name, test := range map[string]struct {
+		status   int
+		body     string
+		kind     error
+		rejected []Rejection
+	}{
+		"recipient": {http.StatusBadRequest, `{"errors":[{"message":"Does not contain a valid address.","field":"personalizations.0.cc.0.email"}]}`,
+			ErrRecipientRejected, []Rejection{{Recipient: "boss@example.com", Code: "400"}}},
+		"unauthorized": {http.StatusUnauthorized, `{"errors":[{"message":"The provided authorization grant is invalid"}]}`, ErrUnauthorized, nil},
+		"rate limited": {http.StatusTooManyRequests, `{"errors":[{"message":"too many requests"}]}`, ErrRateLimited, nil},
+		"provider":     {http.StatusBadGateway, `bad gateway`, ErrProvider, nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server, _ := standIn(t, func(w http.ResponseWriter, r apiRequest) { reply(w, test.status, test.body) })
+			_, err := SendGridTransport{API: APIConfig{BaseURL: server.URL, Key: "sg-key"}}.Send(context.Background(), raw)
+			checkSendError(t, err, test.kind, test.rejected...)
+		})
+	}
+}
+
+func TestMailgunTransport(t *testing.T) {
+	raw := testRawMessage(t)
+
+	var form struct {
+		to      []string
+		message []byte
+	}
+	server, requests := standIn(t, func(w http.ResponseWriter, r apiRequest) {
+		req, _ := http.NewRequest(r.method, "/", bytes.NewReader(r.body))
+		req.Header = r.header
+		if err := req.ParseMultipartForm(1 << 20); err == nil {
+			form.to = req.MultipartForm.Value["to"]
+			if files := req.MultipartForm.File["message"]; len(files) == 1 {
+				f, _ := files[0].Open()
+				form.message, _ = io.ReadAll(f)
+			}
+		}
+		reply(w, http.StatusOK, `{"id":"<mg-123@example.com>","message":"Queued. Thank you."}`)
+	})
+	transport := MailgunTransport{API: APIConfig{BaseURL: server.URL, Key: "mg-key", Domain: "example.com"}}
+
+	response, err := transport.Send(context.Background(), raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(response, "message id <mg-123@example.com>") {
+		t.Errorf("response = %q, want the message id", response)
+	}
+
+	req := (*requests)[0]
+	if req.method != http.MethodPost || req.path != "/v3/example.com/messages.mime" {
+		t.Errorf("request = %s %s, want POST /v3/example.com/messages.mime", req.method, req.path)
+	}
+	if auth := req.header.Get("Authorization"); auth != "Basic "+base64.StdEncoding.EncodeToString([]byte("api:mg-key")) {
+		t.Errorf("Authorization = %q, want basic auth as api", auth)
+	}
+	if !slices.Equal(form.to, raw.Rcpts) {
+		t.Errorf("to = %v, want the envelope %v", form.to, raw.Rcpts)
+	}
+	if !bytes.Equal(form.message, raw.Data) {
+		t.Error("the message wasn't sent as it was rendered")
+	}
+
+	for name, test := range map[string]struct {
+		status   int
+		body     string
+		kind     error
+		rejected []Rejection
+	}{
+		"recipient":    {http.StatusBadRequest, `{"message":"to parameter is not a valid address. please check documentation: audit@example.com"}`, ErrRecipientRejected, []Rejection{{Recipient: "audit@example.com", Code: "400"}}},
+		"unauthorized": {http.StatusUnauthorized, `Forbidden`, ErrUnauthorized, nil},
+		"rate limited": {http.StatusTooManyRequests, `{"message":"Too many requests"}`, ErrRateLimited, nil},
+		"provider":     {http.StatusInternalServerError, `{"message":"internal error"}`, ErrProvider, nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server, _ := standIn(t, func(w http.ResponseWriter, r apiRequest) { reply(w, test.status, test.body) })
+			_, err := MailgunTransport{API: APIConfig{BaseURL: server.URL, Key: "mg-key", Domain: "example.com"}}.Send(context.Background(), raw)
+			checkSendError(t, err, test.kind, test.rejected...)
+		})
+	}
+}
+
+// Checks the request's AWS Signature Version 4 as AWS would, from what
+// the stand-in received.
+func checkSigV4(t *testing.T, req apiRequest, api APIConfig) {
+	t.Helper()
+
+	auth := req.header.Get("Authorization")
+	date := req.header.Get("X-Amz-Date")
+	if len(date) != len("20060102T150405Z") {
+		t.Fatalf("X-Amz-Date = %q", date)
+	}
+	if token := req.header.Get("X-Amz-Security-Token"); token != api.Token {
+		t.Errorf("X-Amz-Security-Token = %q, want %q", token, api.Token)
+	}
+
+	scope := fmt.Sprintf("%s/%s/ses/aws4_request", date[:8], api.Region)
+	prefix := fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=", api.Key, scope)
+	if !strings.HasPrefix(auth, prefix) {
+		t.Fatalf("Authorization = %q, want it to start with %q", auth, prefix)
+	}
+	signed, signature, _ := strings.Cut(strings.TrimPrefix(auth, prefix), ", Signature=")
+
+	var headers strings.Builder
+	for _, name := range strings.Split(signed, ";") {
+		value := req.header.Get(name)
+		if name == "host" {
+			value = req.host
+		}
+		fmt.Fprintf(&headers, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+	canonical := strings.Join([]string{req.method, req.path, "", headers.String(), signed, hexSHA256(req.body)}, "\n")
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", date, scope, hexSHA256([]byte(canonical))}, "\n")
+
+	key := []byte("AWS4" + api.Secret)
+	for _, part := range []string{date[:8], api.Region, "ses", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	if want := hex.EncodeToString(hmacSHA256(key, toSign)); signature != want {
+		t.Errorf("Signature = %s, want %s", signature, want)
+	}
+	if !strings.Contains(signed, "host") || !strings.Contains(signed, "x-amz-date") {
+		t.Errorf("SignedHeaders = %q, want host and x-amz-date", signed)
+	}
+}
+
+func TestSESTransport(t *testing.T) {
+	raw := testRawMessage(t)
+
+	server, requests := standIn(t, func(w http.ResponseWriter, r apiRequest) {
+		reply(w, http.StatusOK, `{"MessageId":"ses-123"}`)
+	})
+	api := APIConfig{BaseURL: server.URL, Key: "AKIDEXAMPLE", Secret: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", Token: "session", Region: "ap-southeast-1"}
+
+	response, err := SESTransport{API: api}.Send(context.Background(), raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(response, "message id ses-123") {
+		t.Errorf("response = %q, want the message id ses-123", response)
+	}
+
+	req := (*requests)[0]
+	if req.method != http.MethodPost || req.path != "/v2/email/outbound-emails" {
+		t.Errorf("request = %s %s, want POST /v2/email/outbound-emails", req.method, req.path)
+	}
+	checkSigV4(t, req, api)
+
+	var body struct {
+		FromEmailAddress string
+		Destination      struct{ ToAddresses []string }
+		Content          struct{ Raw struct{ Data []byte } }
+	}
+	if err := json.Unmarshal(req.body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.FromEmailAddress != raw.From || !slices.Equal(body.Destination.ToAddresses, raw.Rcpts) {
+		t.Errorf("envelope = %s %v, want %s %v", body.FromEmailAddress, body.Destination.ToAddresses, raw.From, raw.Rcpts)
+	}
+	if !bytes.Equal(body.Content.Raw.Data, raw.Data) {
+		t.Error("the message wasn't sent as it was rendered")
+	}
+
+	for name, test := range map[string]struct {
+		status   int
+		code     string
+		message  string
+		kind     error
+		rejected []Rejection
+	}{
+		"recipient": {http.StatusBadRequest, "MessageRejected", "Email address is not verified. The following identities failed the check in region AP-SOUTHEAST-1: jane@example.com",
+			ErrRecipientRejected, []Rejection{{Recipient: "jane@example.com", Code: "MessageRejected"}}},
+		"throttled":    {http.StatusBadRequest, "ThrottlingException", "Rate exceeded", ErrRateLimited, nil},
+		"unauthorized": {http.StatusForbidden, "InvalidSignatureException", "The request signature we calculated does not match", ErrUnauthorized, nil},
+		"provider":     {http.StatusServiceUnavailable, "", "Service unavailable", ErrProvider, nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server, _ := standIn(t, func(w http.ResponseWriter, r apiRequest) {
+				if test.code != "" {
+					w.Header().Set("X-Amzn-Errortype", test.code+":http://internal.amazon.com/coral/com.amazonaws.sesv2/")
+				}
+				reply(w, test.status, fmt.Sprintf(`{"message":%q}`, test.message))
+			})
+			api.BaseURL = server.URL
+			_, err := SESTransport{API: api}.Send(context.Background(), raw)
+			checkSendError(t, err, test.kind, test.rejected...)
+		})
+	}
+}
+
+func TestSESSignIsStable(t *testing.T) {
+	api := APIConfig{Key: "AKIDEXAMPLE", Secret: "secret", Region: "us-east-1"}
+	now := time.Date(2026, 11, 3, 7, 30, 0, 0, time.UTC)
+
+	sign := func() string {
+		req, err := newJSONRequest(context.Background(), "https://email.us-east-1.amazonaws.com/v2/email/outbound-emails", map[string]string{"a": "b"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := (SESTransport{API: api}).sign(req, now); err != nil {
+			t.Fatal(err)
+		}
+		if body, _ := io.ReadAll(req.Body); string(body) != `{"a":"b"}` {
+			t.Errorf("signing changed the body to %q", body)
+		}
+		return req.Header.Get("Authorization")
+	}
+
+	first := sign()
+	if !strings.HasPrefix(first, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20261103/us-east-1/ses/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=") {
+		t.Errorf("Authorization = %q", first)
+	}
+	if second := sign(); second != first {
+		t.Errorf("the same request was signed as %q and %q", first, second)
+	}
+}
+
+func TestPostmarkTransport(t *testing.T) {
+	raw := testRawMessage(t)
+
+	server, requests := standIn(t, func(w http.ResponseWriter, r apiRequest) {
+		reply(w, http.StatusOK, `{"ErrorCode":0,"Message":"OK","MessageID":"pm-123"}`)
+	})
+	transport := PostmarkTransport{API: APIConfig{BaseURL: server.URL, Key: "pm-token", Stream: "outbound"}}
+
+	response, err := transport.Send(context.Background(), raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(response, "message id pm-123") {
+		t.Errorf("response = %q, want the message id pm-123", response)
+	}
+
+	req := (*requests)[0]
+	if req.method != http.MethodPost || req.path != "/email" {
+		t.Errorf("request = %s %s, want POST /email", req.method, req.path)
+	}
+	if token := req.header.Get("X-Postmark-Server-Token"); token != "pm-token" {
+		t.Errorf("X-Postmark-Server-Token = %q, want pm-token", token)
+	}
+
+	var body postmarkEmail
+	if err := json.Unmarshal(req.body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body.From, "monitoring@example.com") || !strings.Contains(body.To, "jane@example.com") ||
+		body.Cc != "<boss@example.com>" || body.Bcc != "<audit@example.com>" ||
+		body.Subject != "Important Reminder for Late Interns" || body.MessageStream != "outbound" ||
+		!strings.Contains(body.HtmlBody, "Late Interns") {
+		t.Errorf("unexpected body: %s", req.body)
+	}
+	if !slices.ContainsFunc(body.Attachments, func(a postmarkAttachment) bool { return a.ContentID == "cid:logo" }) {
+		t.Error("the logo wasn't sent inline")
+	}
+
+	for name, test := range map[string]struct {
+		status   int
+		body     string
+		kind     error
+		rejected []Rejection
+	}{
+		"inactive recipient": {http.StatusUnprocessableEntity, `{"ErrorCode":406,"Message":"You tried to send to recipient(s) that have been marked as inactive. Found inactive addresses: boss@example.com."}`,
+			ErrRecipientRejected, []Rejection{{Recipient: "boss@example.com", Code: "406"}}},
+		"bad token":    {http.StatusUnprocessableEntity, `{"ErrorCode":10,"Message":"Bad or missing API token"}`, ErrUnauthorized, nil},
+		"rate limited": {http.StatusTooManyRequests, `{"ErrorCode":0,"Message":"Too many requests"}`, ErrRateLimited, nil},
+		"rejected":     {http.StatusUnprocessableEntity, `{"ErrorCode":412,"Message":"Your account is pending approval"}`, ErrRejected, nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server, _ := standIn(t, func(w http.ResponseWriter, r apiRequest) { reply(w, test.status, test.body) })
+			_, err := PostmarkTransport{API: APIConfig{BaseURL: server.URL, Key: "pm-token"}}.Send(context.Background(), raw)
+			checkSendError(t, err, test.kind, test.rejected...)
+		})
+	}
+}
+
+func TestSendNextKeepsRejections(t *testing.T) {
+	t.Setenv("QUEUE_DIR", t.TempDir())
+
+	job, err := QueueBatch("LATE", Late, []User{{Email: "jane@example.com"}}, EmailConfig{From: User{Email: "monitoring@example.com"}}, time.Now(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := &MemoryTransport{Err: newSendError(TransportPostmark, 422, "406", "inactive: jane@example.com", ErrRejected, []string{"jane@example.com"})}
+	m, _, err := job.SendNext(context.Background(), transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.State != Failed || len(m.Rejected) != 1 || m.Rejected[0].Recipient != "jane@example.com" || m.Rejected[0].Code != "406" {
+		t.Errorf("message = %+v, want it failed with jane@example.com rejected", m)
+	}
+	if reason := m.Reason(); reason != "rejected jane@example.com (406)" {
+		t.Errorf("Reason = %q", reason)
+	}
+
+	// The rejections are kept in the queue
+	saved, err := readJob(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := saved.Messages[0].Rejected; len(got) != 1 || got[0].Code != "406" {
+		t.Errorf("queued rejections = %v", got)
+	}
+}
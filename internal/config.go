@@ -3,38 +3,84 @@
 package email
 
 import (
+	"cmp"
 	"fmt"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type EmailConfig struct {
+	Profile  string // Profile of the config file, if any
+	SMTPHost string
+	SMTPPort int
 	SMTPUser string
 	SMTPPass string
-	From     User
-	CC       User
+	Auth     string      // AuthPlain or AuthXOAUTH2
+	OAuth    OAuthConfig // Used with AuthXOAUTH2
+
+	Transport    string    // One of the Transport* names
+	SendmailPath string    // Used with TransportSendmail
+	MailDir      string    // Used with TransportFile and TransportMaildir
+	API          APIConfig // Used with the HTTP API transports
+
+	DKIM  *DKIMSigner  // Signs every message if set
+	SMIME *SMIMESigner // Signs, and may encrypt, every message if set
+
+	SecretLinks *SecretLinks // Puts passwords behind one-time links if set
+
+	Location *time.Location    // Time zone of the dates in templates
+	Locale   string            // Locale of recipients without a language, e.g. fil
+	Vars     map[string]string // Variables of the batch for templates, see TemplateContext
+
+	From      User
+	CC        []User   // Copied on every email
+	BCC       []User   // Blind copied on every email
+	Templates []string // Templates that can be sent, all if empty
+	Warnings  []string // Settings that work but should be fixed
 }
 
-// Reads .env file and returns the appropriate EmailConfig.
+// Reads the settings merged by LoadSettings, or the .env file, and
+// returns the appropriate EmailConfig.
 func LoadConfig() (EmailConfig, error) {
-	err := godotenv.Load()
+	config, err := loadConfig()
 	if err != nil {
-		return EmailConfig{}, fmt.Errorf("failed to load .env file")
+		logger.Error("config load failed", "error", err)
+		return config, err
 	}
 
-	smtpUser := os.Getenv("SMTP_EMAIL")
-	if smtpUser == "" {
-		return EmailConfig{}, fmt.Errorf("SMTP_USER environment variable not set")
+	for _, warning := range config.Warnings {
+		logger.Warn("config warning", "warning", warning)
 	}
 
-	if !IsValidEmail(smtpUser) {
-		return EmailConfig{}, fmt.Errorf("invalid SMTP email")
-	}
+	logger.Info("config loaded",
+		"profile", config.Profile,
+		"smtp_host", config.SMTPHost,
+		"smtp_port", config.SMTPPort,
+		"smtp_user", config.SMTPUser,
+		"auth", config.Auth,
+		"transport", config.Transport,
+		"from", config.From.String(),
+		"cc", JoinUsers(config.CC),
+		"bcc", JoinUsers(config.BCC))
+
+	return config, nil
+}
+
+func loadConfig() (EmailConfig, error) {
+	// The .env file is optional, the settings may come from elsewhere
+	_ = godotenv.Load()
 
-	smtpPass := os.Getenv("SMTP_PASS")
-	if smtpPass == "" {
-		return EmailConfig{}, fmt.Errorf("SMTP_PASS environment variable not set")
+	config := EmailConfig{
+		Profile:      os.Getenv("PROFILE"),
+		Transport:    strings.ToLower(getenv("TRANSPORT", TransportSMTP)),
+		SendmailPath: getenv("SENDMAIL_PATH", "/usr/sbin/sendmail"),
+		MailDir:      getenv("MAIL_DIR", "outbox"),
+		Warnings:     []string{},
 	}
 
 	fromUsername := os.Getenv("SENDER_NAME")
@@ -51,19 +97,192 @@ func LoadConfig() (EmailConfig, error) {
 		return EmailConfig{}, fmt.Errorf("invalid sender email")
 	}
 
-	ccUsername := os.Getenv("CC_NAME")
-	ccEmail := os.Getenv("CC_EMAIL")
-
-	return EmailConfig{
-		SMTPUser: smtpUser,
-		SMTPPass: smtpPass,
-		From: User{
-			Name:  fromUsername,
-			Email: fromEmail,
-		},
-		CC: User{
-			Name:  ccUsername,
-			Email: ccEmail,
-		},
-	}, nil
+	config.From = User{Name: fromUsername, Email: fromEmail}
+
+	secrets, err := OpenSecretStore("")
+	if err != nil {
+		return EmailConfig{}, err
+	}
+
+	config.Location, err = time.LoadLocation(getenv("TIMEZONE", "Local"))
+	if err != nil {
+		return EmailConfig{}, fmt.Errorf("invalid TIMEZONE, use a name like Asia/Manila: %w", err)
+	}
+
+	config.Locale, err = ParseLocale(getenv("DEFAULT_LOCALE", DefaultLocale))
+	if err != nil {
+		return EmailConfig{}, fmt.Errorf("invalid DEFAULT_LOCALE: %w", err)
+	}
+	config.Locale = cmp.Or(config.Locale, DefaultLocale)
+
+	// Only the chosen transport's settings are needed
+	switch config.Transport {
+	case TransportSMTP:
+		err = loadSMTPConfig(&config, secrets)
+	case TransportSendGrid, TransportMailgun, TransportSES, TransportPostmark:
+		config.API, err = loadAPIConfig(config.Transport, config.From, secrets)
+	case TransportSendmail, TransportFile, TransportMaildir, TransportMemory:
+	default:
+		err = fmt.Errorf("unknown TRANSPORT %q", config.Transport)
+	}
+	if err != nil {
+		return EmailConfig{}, err
+	}
+
+	config.DKIM, err = loadDKIMConfig(config.From)
+	if err != nil {
+		return EmailConfig{}, err
+	}
+
+	config.SecretLinks, err = LoadSecretLinks()
+	if err != nil {
+		return EmailConfig{}, err
+	}
+
+	var smimeWarnings []string
+	config.SMIME, smimeWarnings, err = loadSMIMEConfig(config.From, secrets)
+	if err != nil {
+		return EmailConfig{}, err
+	}
+	config.Warnings = append(config.Warnings, smimeWarnings...)
+
+	// These providers build the email again from its parts, which would
+	// undo any signature. They sign with their own DKIM keys.
+	if config.Transport == TransportSendGrid || config.Transport == TransportPostmark {
+		if config.DKIM != nil {
+			config.Warnings = append(config.Warnings, fmt.Sprintf("DKIM_* is ignored with %s, which signs emails itself", config.Transport))
+			config.DKIM = nil
+		}
+		if config.SMIME != nil {
+			config.Warnings = append(config.Warnings, fmt.Sprintf("SMIME_* is ignored with %s, which can't send signed emails", config.Transport))
+			config.SMIME = nil
+		}
+	}
+
+	// CC addresses are checked like the sender's, their names are optional
+	config.CC, err = ParseUsers(os.Getenv("CC_EMAIL"))
+	if err != nil {
+		return EmailConfig{}, fmt.Errorf("invalid CC email: %w", err)
+	}
+
+	// CC_NAME names a single CC address given without a name
+	if ccName := os.Getenv("CC_NAME"); ccName != "" {
+		switch {
+		case len(config.CC) == 0:
+			config.Warnings = append(config.Warnings, "CC_NAME is set but CC_EMAIL isn't, so no one is CC'd")
+		case len(config.CC) > 1:
+			config.Warnings = append(config.Warnings, `CC_NAME is ignored since CC_EMAIL has more than one address, write each as "Name <address>" instead`)
+		case config.CC[0].Name != "":
+			config.Warnings = append(config.Warnings, "CC_NAME is ignored since CC_EMAIL already names the address")
+		default:
+			config.CC[0].Name = ccName
+		}
+	}
+
+	config.BCC, err = ParseUsers(os.Getenv("BCC_EMAIL"))
+	if err != nil {
+		return EmailConfig{}, fmt.Errorf("invalid BCC email: %w", err)
+	}
+
+	config.Templates = []string{}
+	for _, name := range strings.Split(os.Getenv("TEMPLATES"), ",") {
+		if name = strings.ToUpper(strings.TrimSpace(name)); name == "" {
+			continue
+		}
+		if _, err := LookupTemplate(name); err != nil {
+			return EmailConfig{}, fmt.Errorf("TEMPLATES: %w", err)
+		}
+		config.Templates = append(config.Templates, name)
+	}
+
+	// A broken template stops everything before a bad email goes out
+	for _, problem := range LintTemplates(config.enabledTemplates(), nil, nil) {
+		if !problem.Warn {
+			return EmailConfig{}, fmt.Errorf("%s, run \"templates lint\" for details", problem)
+		}
+		config.Warnings = append(config.Warnings, problem.String())
+	}
+
+	return config, nil
+}
+
+// Reads the SMTP server, username and password or OAuth2 settings.
+func loadSMTPConfig(config *EmailConfig, secrets SecretStore) error {
+	// SMTP_EMAIL is the old name of SMTP_USER
+	smtpUser := os.Getenv("SMTP_USER")
+	if old := os.Getenv("SMTP_EMAIL"); old != "" {
+		if smtpUser == "" {
+			smtpUser = old
+			config.Warnings = append(config.Warnings, "SMTP_EMAIL is deprecated, rename it to SMTP_USER")
+		} else if old != smtpUser {
+			config.Warnings = append(config.Warnings, "SMTP_EMAIL is deprecated and ignored since SMTP_USER is set")
+		}
+	}
+	if smtpUser == "" {
+		return fmt.Errorf("SMTP_USER environment variable not set")
+	}
+
+	if !IsValidEmail(smtpUser) {
+		return fmt.Errorf("invalid SMTP email")
+	}
+
+	host := "smtp.gmail.com"
+	auth := strings.ToLower(getenv("SMTP_AUTH", AuthPlain))
+
+	switch auth {
+	case AuthPlain:
+		pass, err := secrets.Get("SMTP_PASS")
+		if err != nil {
+			return err
+		}
+		if pass == "" {
+			return fmt.Errorf("SMTP_PASS not set")
+		}
+		config.SMTPPass = pass
+	case AuthXOAUTH2:
+		oauth, err := loadOAuthConfig(secrets)
+		if err != nil {
+			return err
+		}
+		config.OAuth = oauth
+		host = providers[oauth.Provider].host
+	default:
+		return fmt.Errorf("unknown SMTP_AUTH %q", auth)
+	}
+
+	port, err := strconv.Atoi(getenv("SMTP_PORT", "587"))
+	if err != nil || port <= 0 {
+		return fmt.Errorf("invalid SMTP_PORT")
+	}
+
+	config.SMTPHost = getenv("SMTP_HOST", host)
+	config.SMTPPort = port
+	config.SMTPUser = smtpUser
+	config.Auth = auth
+	return nil
+}
+
+// Reports whether the template can be sent with this config.
+func (c EmailConfig) HasTemplate(name string) bool {
+	return len(c.Templates) == 0 || slices.Contains(c.Templates, strings.ToUpper(name))
+}
+
+// Returns the templates that can be sent with this config.
+func (c EmailConfig) enabledTemplates() []Template {
+	templates := []Template{}
+	for _, t := range AllTemplates() {
+		if c.HasTemplate(t.Name) {
+			templates = append(templates, t)
+		}
+	}
+	return templates
+}
+
+// Returns the template with the given short name, if it can be sent
+// with this config.
+func (c EmailConfig) Template(name string) (Template, error) {
+	if !c.HasTemplate(name) {
+		return Template{}, fmt.Errorf("template %s isn't enabled in profile %q", strings.ToUpper(name), c.Profile)
+	}
+	return LookupTemplate(name)
 }
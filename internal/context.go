@@ -0,0 +1,205 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	// Windows has no time zone database for TIMEZONE
+	_ "time/tzdata"
+)
+
+// The data the subject and body of a template are rendered with, e.g.
+// {{.To.Name}}, {{.To.Fields.supervisor}} or {{.Vars.startDate}}.
+// Referring to a field or variable that isn't there is an error, so a
+// typo never sends an email with a blank in it.
+type TemplateContext struct {
+	Subject  string            // The rendered subject line, in the body only
+	To       User              // The recipient, with every column of their row in To.Fields
+	From     User              // The sender
+	Vars     map[string]string // Variables of the whole batch, set with -var or in the app
+	Today    time.Time         // When the email is sent, in TIMEZONE
+	Job      string            // ID of the queued batch, empty for test emails
+	Locale   string            // Locale the email is written in, e.g. fil
+	Password template.HTML     // The password, or the one-time link to it
+}
+
+var varName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Parses a batch variable given as name=value.
+func ParseVar(s string) (string, string, error) {
+	name, value, ok := strings.Cut(s, "=")
+	name = strings.TrimSpace(name)
+	if !ok || !varName.MatchString(name) {
+		return "", "", fmt.Errorf("invalid variable %q, use name=value with a name of letters, digits and _", s)
+	}
+	return name, strings.TrimSpace(value), nil
+}
+
+// Returns the context the email's template is rendered with.
+func (e Email) context() TemplateContext {
+	loc := e.Config.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	today := e.sendAt
+	if today.IsZero() {
+		today = time.Now()
+	}
+
+	return TemplateContext{
+		To:       e.To,
+		From:     e.Config.From,
+		Vars:     e.Config.Vars,
+		Today:    today.In(loc),
+		Job:      e.job,
+		Locale:   e.locale(),
+		Password: template.HTML(e.passwordHTML()),
+	}
+}
+
+// Returns the template in the recipient's language, or in the default
+// locale if there's no translation in it, and the locale it's in.
+func (e Email) localized() (Template, string) {
+	recipient, _ := ParseLocale(e.To.Language)
+	for _, locale := range []string{recipient, e.Config.Locale} {
+		if locale == "" {
+			continue
+		}
+		if t, ok := e.Body.Localize(locale); ok {
+			return t, locale
+		}
+	}
+	return e.Body, DefaultLocale
+}
+
+// Returns the locale the email is written in.
+func (e Email) locale() string {
+	_, locale := e.localized()
+	return locale
+}
+
+// Functions templates can use besides the built-in ones.
+func (e Email) funcs() map[string]any {
+	loc := e.Config.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	locale := e.locale()
+
+	return map[string]any{
+		// {{date "January 2, 2006" .Vars.startDate}}, with the month
+		// and weekday names in the email's locale
+		"date": func(layout string, value any) (string, error) {
+			t, err := toTime(value, loc)
+			if err != nil {
+				return "", err
+			}
+			return formatDate(t, layout, locale), nil
+		},
+		// {{addDays 7 .Today | date "Monday, January 2"}}
+		"addDays": func(days int, value any) (time.Time, error) {
+			t, err := toTime(value, loc)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return t.AddDate(0, 0, days), nil
+		},
+		"firstName": func(name string) string {
+			if fields := strings.Fields(name); len(fields) > 0 {
+				return fields[0]
+			}
+			return ""
+		},
+		"lastName": func(name string) string {
+			if fields := strings.Fields(name); len(fields) > 1 {
+				return fields[len(fields)-1]
+			}
+			return ""
+		},
+		"title":  titleCase,
+		"button": button,
+		// {{translate "Visit us"}}, the layout's text in the email's locale
+		"translate": func(text string) string {
+			return translate(locale, text)
+		},
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+		"trim":  strings.TrimSpace,
+		// {{default "your supervisor" .To.Fields.supervisor}}
+		"default": func(fallback, value string) string {
+			if strings.TrimSpace(value) == "" {
+				return fallback
+			}
+			return value
+		},
+	}
+}
+
+// Layouts of the dates found in input files and variables.
+var dateLayouts = []string{
+	time.DateOnly,
+	time.DateTime,
+	"2006-01-02 15:04",
+	time.RFC3339,
+	"1/2/2006",
+	"1/2/06",
+	"01-02-06",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"2 Jan 2006",
+}
+
+// Returns the value as a time, parsing it in loc if it's a string.
+func toTime(value any, loc *time.Location) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.In(loc), nil
+	case string:
+		v = strings.TrimSpace(v)
+		for _, layout := range dateLayouts {
+			if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("%q isn't a date, write it like 2026-11-03", v)
+	}
+	return time.Time{}, fmt.Errorf("%v isn't a date", value)
+}
+
+// Capitalizes the first letter of each word and lowercases the rest,
+// e.g. "JUAN dela cruz" becomes "Juan Dela Cruz".
+func titleCase(s string) string {
+	words := strings.Fields(s)
+	for i, word := range words {
+		runes := []rune(strings.ToLower(word))
+		runes[0] = unicode.ToUpper(runes[0])
+		words[i] = string(runes)
+	}
+	return strings.Join(words, " ")
+}
+
+// Renders the HTML body of a template with the context.
+func renderBody(t Template, funcs map[string]any, data TemplateContext) (string, error) {
+	tmpl, err := t.parse(funcs)
+	if err != nil {
+		return "", fmt.Errorf("invalid body: %w", err)
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("invalid body: %w", err)
+	}
+
+	if !t.usesLayout() {
+		return sb.String(), nil
+	}
+	return inlineCSS(sb.String())
+}
@@ -0,0 +1,327 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	netmail "net/mail"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// A message received by the dev server.
+type CapturedMessage struct {
+	ID       int
+	Received time.Time
+	User     string // The username it was sent with, if any
+	From     string
+	Rcpts    []string
+	Data     []byte
+}
+
+// A local SMTP server that keeps the messages it receives instead of
+// delivering them, and shows them in a web inbox. Any username and
+// password are accepted.
+type DevServer struct {
+	Dir string // Where messages are also saved as .eml files, if set
+
+	mu       sync.Mutex
+	messages []CapturedMessage
+}
+
+// Runs the SMTP server on smtpAddr and the web inbox on httpAddr until
+// ctx is done.
+func (d *DevServer) Run(ctx context.Context, smtpAddr, httpAddr string) error {
+	smtpListener, err := net.Listen("tcp", smtpAddr)
+	if err != nil {
+		return err
+	}
+
+	httpListener, err := net.Listen("tcp", httpAddr)
+	if err != nil {
+		smtpListener.Close()
+		return err
+	}
+
+	smtpServer := smtp.NewServer(devBackend{d})
+	smtpServer.Domain = "localhost"
+	smtpServer.AllowInsecureAuth = true
+	smtpServer.MaxMessageBytes = 50 << 20
+	smtpServer.ReadTimeout = time.Minute
+	smtpServer.WriteTimeout = time.Minute
+
+	httpServer := &http.Server{Handler: d.handler(), ReadHeaderTimeout: 10 * time.Second}
+
+	errs := make(chan error, 2)
+	go func() { errs <- smtpServer.Serve(smtpListener) }()
+	go func() { errs <- httpServer.Serve(httpListener) }()
+
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+	}
+
+	smtpServer.Close()
+	httpServer.Close()
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Returns the messages received so far, newest first.
+func (d *DevServer) Messages() []CapturedMessage {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	messages := append([]CapturedMessage{}, d.messages...)
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID > messages[j].ID })
+	return messages
+}
+
+func (d *DevServer) message(id int) (CapturedMessage, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if id < 1 || id > len(d.messages) {
+		return CapturedMessage{}, false
+	}
+	return d.messages[id-1], true
+}
+
+func (d *DevServer) add(message CapturedMessage) error {
+	d.mu.Lock()
+	message.ID = len(d.messages) + 1
+	d.messages = append(d.messages, message)
+	d.mu.Unlock()
+
+	logger.Info("devserver received", "id", message.ID, "from", message.From, "recipients", strings.Join(message.Rcpts, ", "))
+
+	if d.Dir == "" {
+		return nil
+	}
+	_, err := FileTransport{Dir: d.Dir}.Send(context.Background(), RawMessage{From: message.From, Rcpts: message.Rcpts, Data: message.Data})
+	return err
+}
+
+type devBackend struct {
+	server *DevServer
+}
+
+func (b devBackend) Login(state *smtp.ConnectionState, username, password string) (smtp.Session, error) {
+	return &devSession{server: b.server, user: username}, nil
+}
+
+func (b devBackend) AnonymousLogin(state *smtp.ConnectionState) (smtp.Session, error) {
+	return &devSession{server: b.server}, nil
+}
+
+type devSession struct {
+	server *DevServer
+	user   string
+	from   string
+	rcpts  []string
+}
+
+func (s *devSession) Reset() {
+	s.from, s.rcpts = "", nil
+}
+
+func (s *devSession) Logout() error {
+	return nil
+}
+
+func (s *devSession) Mail(from string, opts smtp.MailOptions) error {
+	s.from = from
+	return nil
+}
+
+func (s *devSession) Rcpt(to string) error {
+	s.rcpts = append(s.rcpts, to)
+	return nil
+}
+
+func (s *devSession) Data(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	return s.server.add(CapturedMessage{
+		Received: time.Now(),
+		User:     s.user,
+		From:     s.from,
+		Rcpts:    s.rcpts,
+		Data:     data,
+	})
+}
+
+func (d *DevServer) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		type row struct {
+			CapturedMessage
+			Subject string
+		}
+
+		rows := []row{}
+		for _, m := range d.Messages() {
+			parsed, err := parseMessage(RawMessage{Rcpts: m.Rcpts, Data: m.Data})
+			if err != nil {
+				parsed.Subject = "(unreadable message)"
+			}
+			rows = append(rows, row{m, parsed.Subject})
+		}
+
+		renderPage(w, inboxPage, rows)
+	})
+
+	mux.HandleFunc("GET /messages/{id}", d.withMessage(func(w http.ResponseWriter, r *http.Request, m CapturedMessage) {
+		parsed, err := parseMessage(RawMessage{Rcpts: m.Rcpts, Data: m.Data})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		header, _ := netmail.ReadMessage(bytes.NewReader(m.Data))
+		keys := []string{}
+		for key := range header.Header {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		type field struct{ Name, Value string }
+		fields := []field{}
+		for _, key := range keys {
+			for _, value := range header.Header[key] {
+				fields = append(fields, field{key, value})
+			}
+		}
+
+		renderPage(w, messagePage, map[string]any{
+			"Message": m,
+			"Parsed":  parsed,
+			"Headers": fields,
+		})
+	}))
+
+	// The HTML part, with its inline images pointed at the server
+	mux.HandleFunc("GET /messages/{id}/html", d.withMessage(func(w http.ResponseWriter, r *http.Request, m CapturedMessage) {
+		parsed, err := parseMessage(RawMessage{Rcpts: m.Rcpts, Data: m.Data})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		html := strings.ReplaceAll(parsed.HTML, "cid:", fmt.Sprintf("/messages/%d/cid/", m.ID))
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		io.WriteString(w, html)
+	}))
+
+	mux.HandleFunc("GET /messages/{id}/cid/{cid}", d.withMessage(func(w http.ResponseWriter, r *http.Request, m CapturedMessage) {
+		parsed, err := parseMessage(RawMessage{Rcpts: m.Rcpts, Data: m.Data})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		for _, a := range parsed.Attachments {
+			if a.ContentID == r.PathValue("cid") {
+				w.Header().Set("Content-Type", a.ContentType)
+				w.Write(a.Data)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+
+	mux.HandleFunc("GET /messages/{id}/raw", d.withMessage(func(w http.ResponseWriter, r *http.Request, m CapturedMessage) {
+		w.Header().Set("Content-Type", "message/rfc822")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="message-%d.eml"`, m.ID))
+		w.Write(m.Data)
+	}))
+
+	return mux
+}
+
+func (d *DevServer) withMessage(handle func(http.ResponseWriter, *http.Request, CapturedMessage)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		m, ok := d.message(id)
+		if err != nil || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		handle(w, r, m)
+	}
+}
+
+func renderPage(w http.ResponseWriter, page *template.Template, data any) {
+	var buf bytes.Buffer
+	if err := page.Execute(&buf, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+const devStyle = `<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 6px 10px; border-bottom: 1px solid #ddd; vertical-align: top; }
+pre { white-space: pre-wrap; background: #f6f6f6; padding: 1em; }
+iframe { width: 100%; height: 70vh; border: 1px solid #ddd; }
+</style>`
+
+var inboxPage = template.Must(template.New("inbox").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Inbox</title>` + devStyle + `</head>
+<body>
+<h1>Inbox</h1>
+{{if not .}}<p>No messages yet. Reload once something is sent.</p>{{else}}
+<table>
+<tr><th>#</th><th>Received</th><th>From</th><th>To</th><th>Subject</th></tr>
+{{range .}}<tr>
+<td>{{.ID}}</td>
+<td>{{.Received.Format "2006-01-02 15:04:05"}}</td>
+<td>{{.From}}</td>
+<td>{{range $i, $r := .Rcpts}}{{if $i}}, {{end}}{{$r}}{{end}}</td>
+<td><a href="/messages/{{.ID}}">{{.Subject}}</a></td>
+</tr>{{end}}
+</table>{{end}}
+</body></html>`))
+
+var messagePage = template.Must(template.New("message").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Parsed.Subject}}</title>` + devStyle + `</head>
+<body>
+<p><a href="/">Inbox</a> · <a href="/messages/{{.Message.ID}}/raw">Download .eml</a></p>
+<h1>{{.Parsed.Subject}}</h1>
+<p>From {{.Message.From}} to {{range $i, $r := .Message.Rcpts}}{{if $i}}, {{end}}{{$r}}{{end}}{{with .Message.User}}, logged in as {{.}}{{end}}</p>
+
+<h2>HTML</h2>
+{{if .Parsed.HTML}}<iframe sandbox src="/messages/{{.Message.ID}}/html"></iframe>{{else}}<p>No HTML part.</p>{{end}}
+
+<h2>Text</h2>
+{{if .Parsed.Text}}<pre>{{.Parsed.Text}}</pre>{{else}}<p>No text part.</p>{{end}}
+
+{{with .Parsed.Attachments}}<h2>Attachments</h2>
+<ul>{{range .}}<li>{{.Name}} ({{.ContentType}}{{with .ContentID}}, inline as {{.}}{{end}}, {{len .Data}} bytes)</li>{{end}}</ul>{{end}}
+
+<h2>Headers</h2>
+<table>{{range .Headers}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>{{end}}</table>
+</body></html>`))
@@ -0,0 +1,270 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Headers signed unless DKIM_HEADERS is set. Those missing from a
+// message are skipped.
+var defaultDKIMHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID",
+	"MIME-Version", "Content-Type", "List-Unsubscribe",
+}
+
+// Adds a DKIM-Signature to messages, with relaxed canonicalization of
+// the headers and body (RFC 6376). RSA and Ed25519 (RFC 8463) keys are
+// supported.
+type DKIMSigner struct {
+	Domain   string
+	Selector string
+	Headers  []string
+	Key      crypto.Signer
+}
+
+func loadDKIMConfig(from User) (*DKIMSigner, error) {
+	keyFile := os.Getenv("DKIM_PRIVATE_KEY")
+	selector := os.Getenv("DKIM_SELECTOR")
+	if keyFile == "" && selector == "" {
+		return nil, nil
+	}
+	if keyFile == "" || selector == "" {
+		return nil, fmt.Errorf("DKIM_PRIVATE_KEY and DKIM_SELECTOR must be set together to sign with DKIM")
+	}
+
+	_, domain, _ := strings.Cut(from.Email, "@")
+	signer := &DKIMSigner{
+		Domain:   getenv("DKIM_DOMAIN", domain),
+		Selector: selector,
+		Headers:  defaultDKIMHeaders,
+	}
+
+	if headers := os.Getenv("DKIM_HEADERS"); headers != "" {
+		signer.Headers = []string{}
+		for _, name := range strings.Split(headers, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				signer.Headers = append(signer.Headers, name)
+			}
+		}
+		if !slices.ContainsFunc(signer.Headers, func(name string) bool { return strings.EqualFold(name, "From") }) {
+			return nil, fmt.Errorf("DKIM_HEADERS must include From")
+		}
+	}
+
+	var err error
+	if signer.Key, err = readPrivateKey(keyFile); err != nil {
+		return nil, fmt.Errorf("invalid DKIM_PRIVATE_KEY: %w", err)
+	}
+
+	return signer, nil
+}
+
+// Reads a PEM encoded RSA or Ed25519 private key.
+func readPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("%s must hold an RSA or Ed25519 key", path)
+}
+
+func (s *DKIMSigner) algorithm() string {
+	if _, ok := s.Key.(ed25519.PrivateKey); ok {
+		return "ed25519-sha256"
+	}
+	return "rsa-sha256"
+}
+
+// Returns the DNS name and TXT record that receivers look the public
+// key up at.
+func (s *DKIMSigner) Record() (string, string, error) {
+	name := s.Selector + "._domainkey." + s.Domain
+
+	if key, ok := s.Key.Public().(ed25519.PublicKey); ok {
+		return name, "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(key), nil
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(s.Key.Public())
+	if err != nil {
+		return "", "", err
+	}
+	return name, "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der), nil
+}
+
+// Looks the record up in DNS and returns an error if it isn't published
+// or holds a different key.
+func (s *DKIMSigner) CheckRecord() error {
+	name, want, err := s.Record()
+	if err != nil {
+		return err
+	}
+
+	records, err := net.LookupTXT(name)
+	if err != nil {
+		return fmt.Errorf("no TXT record at %s: %w", name, err)
+	}
+
+	wantKey := dkimTag(want, "p")
+	for _, record := range records {
+		if dkimTag(record, "p") == wantKey {
+			return nil
+		}
+	}
+	return fmt.Errorf("the TXT record at %s doesn't hold this key", name)
+}
+
+// Returns the value of a tag of a DKIM record, without whitespace.
+func dkimTag(record, tag string) string {
+	for _, part := range strings.Split(record, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if ok && strings.TrimSpace(name) == tag {
+			return strings.Join(strings.Fields(value), "")
+		}
+	}
+	return ""
+}
+
+// Returns the message with a DKIM-Signature header added at the top.
+func (s *DKIMSigner) Sign(raw RawMessage) ([]byte, error) {
+	data := crlf(raw.Data)
+
+	header, body, ok := bytes.Cut(data, []byte("\r\n\r\n"))
+	if !ok {
+		return nil, errors.New("message has no body")
+	}
+
+	fields := splitHeader(string(header) + "\r\n")
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	// Each name signs the last of its headers not signed yet
+	used := map[int]bool{}
+	signed := []string{}
+	var canonical strings.Builder
+	for _, name := range s.Headers {
+		for i := len(fields) - 1; i >= 0; i-- {
+			fieldName, _, _ := strings.Cut(fields[i], ":")
+			if used[i] || !strings.EqualFold(strings.TrimSpace(fieldName), name) {
+				continue
+			}
+			used[i] = true
+			signed = append(signed, strings.ToLower(name))
+			canonical.WriteString(relaxedHeader(fields[i]) + "\r\n")
+			break
+		}
+	}
+
+	signature := fmt.Sprintf("DKIM-Signature: v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s;\r\n\tt=%d; h=%s;\r\n\tbh=%s;\r\n\tb=",
+		s.algorithm(), s.Domain, s.Selector, time.Now().Unix(), strings.Join(signed, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]))
+	canonical.WriteString(relaxedHeader(signature))
+
+	hash := sha256.Sum256([]byte(canonical.String()))
+
+	var sig []byte
+	var err error
+	if key, ok := s.Key.(ed25519.PrivateKey); ok {
+		// Ed25519 signs the hash, not the headers themselves
+		sig = ed25519.Sign(key, hash[:])
+	} else {
+		sig, err = s.Key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign with DKIM: %w", err)
+	}
+
+	signature += base64.StdEncoding.EncodeToString(sig) + "\r\n"
+	return append([]byte(signature), data...), nil
+}
+
+// Returns the data with CRLF line endings, which is what's signed.
+func crlf(data []byte) []byte {
+	return bytes.ReplaceAll(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+}
+
+// Splits a header block into its fields, each with its folded lines.
+func splitHeader(header string) []string {
+	fields := []string{}
+	for _, line := range strings.SplitAfter(header, "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+			continue
+		}
+		fields = append(fields, line)
+	}
+
+	for i := range fields {
+		fields[i] = strings.TrimSuffix(fields[i], "\r\n")
+	}
+	return fields
+}
+
+var dkimWhitespace = regexp.MustCompile(`[ \t]+`)
+
+// Canonicalizes a header with the relaxed algorithm: a lowercase name,
+// unfolded and with runs of whitespace made a single space.
+func relaxedHeader(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.TrimSpace(dkimWhitespace.ReplaceAllString(value, " "))
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value
+}
+
+// Canonicalizes a body with the relaxed algorithm: runs of whitespace
+// made a single space, no whitespace at line ends and no empty lines at
+// the end.
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(dkimWhitespace.ReplaceAllString(line, " "), " ")
+	}
+
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
@@ -0,0 +1,235 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// The example of RFC 6376 section 3.4.5.
+const rfcHeader = "A: X\r\n" +
+	"B : Y\t\r\n" +
+	"\tZ  \r\n"
+
+const rfcBody = " C \r\n" +
+	"D \t E\r\n" +
+	"\r\n" +
+	"\r\n"
+
+func TestRelaxedHeader(t *testing.T) {
+	fields := splitHeader(rfcHeader)
+	want := []string{"a:X", "b:Y Z"}
+
+	if len(fields) != len(want) {
+		t.Fatalf("split into %q, want %d fields", fields, len(want))
+	}
+	for i, field := range fields {
+		if got := relaxedHeader(field); got != want[i] {
+			t.Errorf("relaxedHeader(%q) = %q, want %q", field, got, want[i])
+		}
+	}
+
+	for field, want := range map[string]string{
+		"Subject:Hello":                   "subject:Hello",
+		"SUBJECT :  Hello   World  ":      "subject:Hello World",
+		"To: Jane\r\n <jane@example.com>": "to:Jane <jane@example.com>",
+		"X-Empty:":                        "x-empty:",
+		"X-Empty: \t ":                    "x-empty:",
+	} {
+		if got := relaxedHeader(field); got != want {
+			t.Errorf("relaxedHeader(%q) = %q, want %q", field, got, want)
+		}
+	}
+}
+
+func TestRelaxedBody(t *testing.T) {
+	for body, want := range map[string]string{
+		rfcBody:                   " C\r\nD E\r\n",
+		"":                        "",
+		"\r\n":                    "",
+		"\r\n\r\n":                "",
+		"Hello":                   "Hello\r\n",
+		"Hello \t\r\n":            "Hello\r\n",
+		"\r\nHello\r\n\r\n":       "\r\nHello\r\n",
+		"a  b\r\n \r\nc\r\n\r\n ": "a b\r\n\r\nc\r\n",
+	} {
+		if got := string(relaxedBody([]byte(body))); got != want {
+			t.Errorf("relaxedBody(%q) = %q, want %q", body, got, want)
+		}
+	}
+
+	// An empty body hashes as nothing at all
+	empty := sha256.Sum256(relaxedBody(nil))
+	if got := base64.StdEncoding.EncodeToString(empty[:]); got != "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=" {
+		t.Errorf("empty body hash = %s", got)
+	}
+}
+
+var dkimTagPattern = regexp.MustCompile(`\bb=[^;]*`)
+
+// Verifies the DKIM-Signature at the top of the message as a receiver
+// would, and returns its tags.
+func verifyDKIM(signed []byte, public crypto.PublicKey) (map[string]string, error) {
+	header, body, _ := bytes.Cut(signed, []byte("\r\n\r\n"))
+	fields := splitHeader(string(header) + "\r\n")
+	signature := fields[0]
+	if !strings.HasPrefix(signature, "DKIM-Signature:") {
+		return nil, fmt.Errorf("first header is %q, want the DKIM-Signature", signature)
+	}
+
+	tags := map[string]string{}
+	_, value, _ := strings.Cut(signature, ":")
+	for _, part := range strings.Split(value, ";") {
+		if name, value, ok := strings.Cut(part, "="); ok {
+			tags[strings.TrimSpace(name)] = strings.Join(strings.Fields(value), "")
+		}
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+	if tags["bh"] != base64.StdEncoding.EncodeToString(bodyHash[:]) {
+		return tags, fmt.Errorf("bh=%s doesn't match the body", tags["bh"])
+	}
+
+	// The signed headers, each the last instance not used yet, then the
+	// signature itself without b= and its CRLF
+	var canonical strings.Builder
+	used := map[int]bool{}
+	for _, name := range strings.Split(tags["h"], ":") {
+		for i := len(fields) - 1; i > 0; i-- {
+			fieldName, _, _ := strings.Cut(fields[i], ":")
+			if !used[i] && strings.EqualFold(strings.TrimSpace(fieldName), name) {
+				used[i] = true
+				canonical.WriteString(relaxedHeader(fields[i]) + "\r\n")
+				break
+			}
+		}
+	}
+	canonical.WriteString(relaxedHeader(dkimTagPattern.ReplaceAllString(signature, "b=")))
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return tags, fmt.Errorf("invalid b=: %w", err)
+	}
+	hash := sha256.Sum256([]byte(canonical.String()))
+
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(public, crypto.SHA256, hash[:], sig); err != nil {
+			return tags, fmt.Errorf("RSA signature doesn't verify: %w", err)
+		}
+	case ed25519.PublicKey:
+		// RFC 8463 signs the SHA-256 hash of the headers, not the headers
+		if !ed25519.Verify(public, hash[:], sig) {
+			return tags, errors.New("Ed25519 signature doesn't verify over the hash of the headers")
+		}
+	}
+
+	return tags, nil
+}
+
+func TestDKIMSign(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The message as it's written, with LF line endings, folded and
+	// repeated headers and trailing whitespace in the body
+	message := "From: Me <me@example.com>\n" +
+		"To: Jane Doe\n <jane@example.com>\n" +
+		"Subject:  Hello   there \n" +
+		"Received: from first\n" +
+		"Received: from second\n" +
+		"Content-Type: text/plain; charset=utf-8\n" +
+		"\n" +
+		"Hello,  Jane. \n" +
+		"\n\n"
+
+	for name, key := range map[string]crypto.Signer{"rsa": testRSAKey(t), "ed25519": edKey} {
+		t.Run(name, func(t *testing.T) {
+			signer := &DKIMSigner{
+				Domain:   "example.com",
+				Selector: "mail",
+				Headers:  []string{"From", "To", "Subject", "Received", "Received", "Received", "Date"},
+				Key:      key,
+			}
+
+			signed, err := signer.Sign(RawMessage{Data: []byte(message)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(bytes.ReplaceAll(signed, []byte("\r\n"), nil), []byte("\n")) {
+				t.Error("the signed message has bare LF line endings")
+			}
+
+			tags, err := verifyDKIM(signed, key.Public())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for tag, want := range map[string]string{
+				"v": "1",
+				"a": signer.algorithm(),
+				"c": "relaxed/relaxed",
+				"d": "example.com",
+				"s": "mail",
+				// Missing headers are skipped, and repeated ones signed
+				// once for each that's there
+				"h": "from:to:subject:received:received",
+			} {
+				if tags[tag] != want {
+					t.Errorf("%s= %q, want %q", tag, tags[tag], want)
+				}
+			}
+
+			// Changing a signed header or the body breaks the signature
+			for _, tampered := range [][]byte{
+				bytes.Replace(signed, []byte("Hello   there"), []byte("Hello   world"), 1),
+				bytes.Replace(signed, []byte("Hello,  Jane."), []byte("Hello,  John."), 1),
+			} {
+				if _, err := verifyDKIM(tampered, key.Public()); err == nil {
+					t.Error("a changed message still verifies")
+				}
+			}
+		})
+	}
+}
+
+func TestDKIMRecord(t *testing.T) {
+	rsaKey := testRSAKey(t)
+	der, err := x509.MarshalPKIXPublicKey(rsaKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		key  crypto.Signer
+		want string
+	}{
+		{rsaKey, "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)},
+		{edKey, "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPublic)},
+	} {
+		name, record, err := (&DKIMSigner{Domain: "example.com", Selector: "mail", Key: test.key}).Record()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != "mail._domainkey.example.com" || record != test.want {
+			t.Errorf("Record = %s %q, want %q", name, record, test.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wneessen/go-mail/smtp"
+)
+
+// The outcome of one check of the config doctor.
+type Check struct {
+	Name   string
+	Detail string
+	Err    error
+	Warn   bool // Passed, but something should be fixed
+}
+
+// Checks the settings, then connects to the SMTP server and
+// authenticates without sending anything. Checks after a failed one
+// that depend on it are skipped.
+func Doctor(ctx context.Context) []Check {
+	config, err := LoadConfig()
+	if err != nil {
+		return []Check{{Name: "Settings", Err: err}}
+	}
+
+	checks := []Check{{Name: "Settings", Detail: settingsDetail(config)}}
+	for _, warning := range config.Warnings {
+		checks = append(checks, Check{Name: "Settings", Detail: warning, Warn: true})
+	}
+
+	checks = append(checks,
+		Check{Name: "Sender", Detail: config.From.String()},
+		Check{Name: "CC", Detail: orNone(JoinUsers(config.CC))},
+		Check{Name: "BCC", Detail: orNone(JoinUsers(config.BCC))},
+		Check{Name: "Templates", Detail: orNone(strings.Join(config.Templates, ", "))},
+	)
+
+	if config.SMIME != nil {
+		cert := config.SMIME.Cert
+		detail := fmt.Sprintf("%s, %s, valid until %s", cert.Subject.CommonName, config.SMIME.Mode, cert.NotAfter.Format(time.DateOnly))
+		checks = append(checks, Check{Name: "S/MIME certificate", Detail: detail})
+	}
+
+	if config.DKIM != nil {
+		name, _, _ := config.DKIM.Record()
+		checks = append(checks, Check{Name: "DKIM record", Detail: name, Err: config.DKIM.CheckRecord()})
+	}
+
+	switch config.Transport {
+	case TransportSMTP:
+		return append(checks, checkServer(ctx, config)...)
+	case TransportSendmail:
+		_, err := exec.LookPath(config.SendmailPath)
+		return append(checks, Check{Name: "Transport sendmail", Detail: config.SendmailPath, Err: err})
+	case TransportSendGrid, TransportMailgun, TransportSES, TransportPostmark:
+		return append(checks, Check{Name: "Transport " + config.Transport, Detail: config.API.BaseURL})
+	case TransportFile, TransportMaildir:
+		return append(checks, Check{Name: "Transport " + config.Transport, Detail: "emails are saved to " + config.MailDir + " instead of being sent", Warn: true})
+	}
+	return append(checks, Check{Name: "Transport " + config.Transport, Detail: "emails are kept in memory and never sent", Warn: true})
+}
+
+func checkServer(ctx context.Context, config EmailConfig) []Check {
+	checks := []Check{}
+	addr := net.JoinHostPort(config.SMTPHost, strconv.Itoa(config.SMTPPort))
+
+	ips, err := net.DefaultResolver.LookupHost(ctx, config.SMTPHost)
+	checks = append(checks, Check{Name: "Resolve " + config.SMTPHost, Detail: strings.Join(ips, ", "), Err: err})
+	if err != nil {
+		return checks
+	}
+
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	checks = append(checks, Check{Name: "Connect to " + addr, Detail: time.Since(start).Round(time.Millisecond).String(), Err: err})
+	if err != nil {
+		return checks
+	}
+
+	client, err := smtp.NewClient(conn, config.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return append(checks, Check{Name: "SMTP greeting", Err: err})
+	}
+	defer client.Close()
+
+	if err := client.Hello("localhost"); err != nil {
+		return append(checks, Check{Name: "SMTP greeting", Err: err})
+	}
+
+	ok, _ := client.Extension("STARTTLS")
+	switch {
+	case !ok && IsLoopback(config.SMTPHost):
+		checks = append(checks, Check{Name: "STARTTLS", Detail: "not offered, which is fine on this PC"})
+	case !ok:
+		return append(checks, Check{Name: "STARTTLS", Err: fmt.Errorf("not offered by the server")})
+	default:
+		err = client.StartTLS(&tls.Config{ServerName: config.SMTPHost})
+		checks = append(checks, Check{Name: "STARTTLS", Err: err})
+		if err != nil {
+			return checks
+		}
+	}
+
+	auth, err := smtpAuth(ctx, config)
+	if err == nil {
+		err = client.Auth(auth)
+	}
+	checks = append(checks, Check{Name: "AUTH " + strings.ToUpper(config.Auth), Detail: config.SMTPUser, Err: err})
+	if err != nil {
+		return checks
+	}
+
+	client.Quit()
+	return checks
+}
+
+// Returns the SMTP authentication for the config's auth method.
+func smtpAuth(ctx context.Context, config EmailConfig) (smtp.Auth, error) {
+	if config.Auth == AuthXOAUTH2 {
+		token, err := config.OAuth.AccessToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return smtp.XOAuth2Auth(config.SMTPUser, token), nil
+	}
+	return smtp.PlainAuth("", config.SMTPUser, config.SMTPPass, config.SMTPHost, false), nil
+}
+
+func settingsDetail(config EmailConfig) string {
+	detail := "via " + config.Transport
+	if config.Transport == TransportSMTP {
+		detail = fmt.Sprintf("%s via %s:%d (%s)", config.SMTPUser, config.SMTPHost, config.SMTPPort, config.Auth)
+	}
+	if config.Profile != "" {
+		detail += ", profile " + config.Profile
+	}
+	return detail
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
@@ -3,8 +3,17 @@
 package email
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"html"
+	"os"
+	"path"
 	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/wneessen/go-mail"
 )
@@ -13,278 +22,291 @@ type Email struct {
 	Body   Template
 	To     User
 	Config EmailConfig
+
+	sendAt       time.Time // When a scheduled email will be sent, zero if now
+	job          string    // ID of the queued batch the email is part of
+	passwordLink string    // The one-time link to the password, once created
+	linkExpires  time.Time
+	test         bool // A test copy, which shows the preview link to the password
 }
 
+// The password of recipients without one in the input file.
+const defaultPassword = "welcome1#"
+
 // Checks if given email address is valid.
 func IsValidEmail(email string) bool {
 	regex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,6}(?:\.[a-zA-Z]{2,})?$`)
 	return regex.MatchString(email)
 }
 
-// Sends the email to the given recipient.
-func (e Email) Send() error {
+// Sends the email to the given recipient through the transport.
+func (e Email) Send(transport Transport) error {
+	message, err := e.Message()
+	if err != nil {
+		return err
+	}
+
+	return deliver(transport, e.Body.Name, message)
+}
+
+// Sends a copy of the email, exactly as the recipient would get it,
+// to the given test address only. The subject is prefixed with [TEST].
+func (e Email) SendTest(transport Transport, to User) error {
+	message, err := e.TestMessage(to)
+	if err != nil {
+		return err
+	}
+
+	return deliver(transport, e.Body.Name, message)
+}
+
+// Builds the message that SendTest delivers to the test address.
+func (e Email) TestMessage(to User) (*mail.Msg, error) {
+	if !IsValidEmail(to.Email) {
+		return nil, fmt.Errorf("invalid test email")
+	}
+
+	// Nobody else gets a copy of a test email
+	e.Config.CC, e.Config.BCC = nil, nil
+	e.To.CC, e.To.BCC = nil, nil
+
+	// Nor a working link to the recipient's password
+	e.test = true
+
+	message, err := e.Message()
+	if err != nil {
+		return nil, err
+	}
+
+	subject, err := e.Subject()
+	if err != nil {
+		return nil, err
+	}
+	message.Subject("[TEST] " + subject)
+
+	if err := message.To(); err != nil {
+		return nil, err
+	}
+	if err := message.AddToFormat(to.Name, to.Email); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// Renders the message and sends it through the transport.
+func deliver(transport Transport, template string, message *mail.Msg) error {
+	raw, err := render(template, message)
+	if err != nil {
+		return err
+	}
+
+	_, err = deliverRaw(context.Background(), transport, raw)
+	return err
+}
+
+// Builds the message that Send delivers to the recipient.
+func (e Email) Message() (*mail.Msg, error) {
+	// The password is put behind a one-time link, if those are set up,
+	// which is removed again if the message can't be built
+	created := e.passwordLink == ""
+	if err := e.createPasswordLink(); err != nil {
+		return nil, err
+	}
+
+	message, err := e.message()
+	if err != nil && created {
+		e.removePasswordLink()
+	}
+	return message, err
+}
+
+func (e Email) message() (*mail.Msg, error) {
 	if !IsValidEmail(e.To.Email) {
-		return fmt.Errorf("invalid recipient email")
+		return nil, fmt.Errorf("invalid recipient email")
 	}
 
 	// Create new email message
 	message := mail.NewMsg()
 
 	// Subject
-	switch e.Body {
-	case Credentials:
-		message.Subject("OfficeTimer Credentials for the Internship in Knowles Training Institute")
-	case Late:
-		message.Subject("Important Reminder for Late Interns")
+	subject, err := e.Subject()
+	if err != nil {
+		return nil, err
 	}
+	message.Subject(subject)
 
 	// Importance
-	message.SetImportance(mail.ImportanceUrgent)
+	if e.Body.Importance != "" {
+		importance, ok := importances[strings.ToLower(e.Body.Importance)]
+		if !ok {
+			return nil, fmt.Errorf("invalid importance %q", e.Body.Importance)
+		}
+		message.SetImportance(importance)
+	}
+
+	// Reply-To
+	if e.Body.ReplyTo != "" {
+		if err := message.ReplyTo(e.Body.ReplyTo); err != nil {
+			return nil, fmt.Errorf("invalid Reply-To: %w", err)
+		}
+	}
+
+	// Other headers
+	if e.Body.ListUnsubscribe != "" {
+		message.SetGenHeader(mail.Header("List-Unsubscribe"), e.Body.ListUnsubscribe)
+	}
+	for name, value := range e.Body.Headers {
+		message.SetGenHeader(mail.Header(name), value)
+	}
+	message.SetGenHeader(mail.Header("Content-Language"), e.locale())
 
 	// CC
-	if e.Config.CC.Exists() {
-		if err := message.AddCcFormat(e.Config.CC.Name, e.Config.CC.Email); err != nil {
-			return err
+	for _, cc := range slices.Concat(e.Config.CC, e.To.CC) {
+		if err := message.AddCcFormat(cc.Name, cc.Email); err != nil {
+			return nil, err
+		}
+	}
+
+	// BCC
+	for _, bcc := range slices.Concat(e.Config.BCC, e.To.BCC) {
+		if err := message.AddBccFormat(bcc.Name, bcc.Email); err != nil {
+			return nil, err
 		}
 	}
 
 	// Recipient
 	if err := message.AddToFormat(e.To.Name, e.To.Email); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Sender
 	if err := message.FromFormat(e.Config.From.Name, e.Config.From.Email); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Email body
-	switch e.Body {
-	case Credentials:
-		message.SetBodyString(
-			mail.TypeTextHTML,
-			fmt.Sprintf(
-				string(e.Body),
-				e.To.Name,
-				e.To.Email,
-				e.To.Email,
-				"welcome1#",
-				e.Config.From.Name,
-				e.Config.From.Email,
-				e.Config.From.Email,
-			),
-		)
-	case Late:
-		message.SetBodyString(
-			mail.TypeTextHTML,
-			fmt.Sprintf(
-				string(e.Body),
-				e.Config.From.Name,
-				e.Config.From.Email,
-				e.Config.From.Email,
-			),
-		)
-	}
-
-	// SMTP server configuration
-	client, err := mail.NewClient("smtp.gmail.com",
-		mail.WithPort(587),
-		mail.WithSMTPAuth(mail.SMTPAuthPlain),
-		mail.WithUsername(e.Config.SMTPUser),
-		mail.WithPassword(e.Config.SMTPPass))
+	body, err := e.HTML()
+	if err != nil {
+		return nil, err
+	}
+	message.SetBodyString(mail.TypeTextHTML, body)
+
+	// Inline images
+	for _, image := range e.Body.Images {
+		data, err := image.Read()
+		if err != nil {
+			return nil, fmt.Errorf("image %s not found: %w", image.CID, err)
+		}
+		if err := message.EmbedReader(path.Base(image.Path), bytes.NewReader(data), mail.WithFileContentID("<"+image.CID+">")); err != nil {
+			return nil, fmt.Errorf("failed to embed image %s: %w", image.CID, err)
+		}
+	}
+
+	// Attachments
+	for _, file := range slices.Concat(e.Body.Attachments, e.To.Attachments) {
+		if _, err := os.Stat(file); err != nil {
+			return nil, fmt.Errorf("attachment %s not found", file)
+		}
+		message.AttachFile(file)
+	}
+
+	return message, nil
+}
 
+// Renders the template's subject line for the recipient, with the
+// same TemplateContext as the body, e.g. {{.To.Name}}.
+func (e Email) Subject() (string, error) {
+	t, _ := e.localized()
+	tmpl, err := template.New(t.Name).Funcs(e.funcs()).Option("missingkey=error").Parse(t.Subject)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("invalid subject: %w", err)
 	}
 
-	// Send email
-	if err := client.DialAndSend(message); err != nil {
-		return err
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, e.context()); err != nil {
+		return "", fmt.Errorf("invalid subject: %w", err)
+	}
+
+	return sb.String(), nil
+}
+
+// Renders the HTML body of the email for the recipient.
+func (e Email) HTML() (string, error) {
+	subject, err := e.Subject()
+	if err != nil {
+		return "", err
+	}
+
+	data := e.context()
+	data.Subject = subject
+
+	t, _ := e.localized()
+	body, err := renderBody(t, e.funcs(), data)
+	if err != nil {
+		return "", err
+	}
+
+	return body, nil
+}
+
+// Creates the one-time link to the password, unless the template shows
+// it as it is, the email is a test or the link exists already.
+func (e *Email) createPasswordLink() error {
+	if !e.Body.SecretPassword || e.Config.SecretLinks == nil || e.test || e.passwordLink != "" {
+		return nil
+	}
+
+	start := e.sendAt
+	if start.IsZero() {
+		start = time.Now()
 	}
 
+	var err error
+	e.passwordLink, e.linkExpires, err = e.Config.SecretLinks.Create(e.password(), e.To.Email, start)
+	if err != nil {
+		return fmt.Errorf("failed to create the password link: %w", err)
+	}
 	return nil
 }
 
-type Template string
-
-const (
-	// Credentials template
-	Credentials Template = `
-  <!DOCTYPE html>
-  <html>
-  <head>
-    <meta charset="utf-8">
-    <title>OfficeTimer Credentials for the Internship in Knowles Training Institute</title>
-  </head>
-  <body style="margin: 0; padding: 15px; background-color: #e9f1f7; font-family: Arial, sans-serif;">
-    <table role="presentation" width="100%%" height="100%%" cellspacing="0" cellpadding="0" border="0">
-      <tr>
-        <td align="center" valign="middle">
-          <table role="presentation" width="600" cellspacing="0" cellpadding="0" border="0" style="background-color: white; border-radius: 10px; box-shadow: 0 4px 6px rgba(0, 0, 0, 0.1), 0 10px 15px rgba(0, 0, 0, 0.05);">
-            <!-- Logo Section -->
-            <tr>
-              <td align="center" valign="middle" style="padding: 20px 20px;">
-                <a href="https://www.knowlesti.sg" target="_blank" style="display: inline-block;">
-                  <img src="https://i.imgur.com/Q9nLEZA.png" width="200" alt="Knowles Training Institute" style="border: 0; display: block;">
-                </a>
-              </td>
-            </tr>
-
-            <!-- Divider -->
-            <tr>
-              <td align="center" style="padding: 0px 40px;">
-                <div style="height: 1px; background-color: #edf2f7;"></div>
-              </td>
-            </tr>
-
-            <!-- Title Section -->
-            <tr>
-              <td align="center" style="padding: 20px 40px 0;">
-                <h1 style="color: #1a365d; font-size: 24px; margin: 0; font-family: Arial, sans-serif;">Welcome, %s!</h1>
-                <p style="color: #4a5568; font-size: 16px; padding: 10px; margin: 0; font-family: Arial, sans-serif;">Here is your OfficeTimer account.</p>
-              </td>
-            </tr>
-
-            <!-- Credentials Section -->
-            <tr>
-              <td align="center" style="padding: 20px 40px;">
-                <table role="presentation" cellspacing="0" cellpadding="0" border="0" style="background-color: #f8fafc; border-radius: 8px; border: 1px solid #e2e8f0; width: 90%%;">
-                  <tr>
-                    <td style="padding: 30px;">
-                      <p style="margin: 0; color: #333; font-size: 16px; line-height: 1.6;">
-                        <span style="color: #4a5568;">Username:</span> 
-                        <strong><a href="mailto:%s" style="color: #2b6cb0; text-decoration: none;">%s</a></strong>
-                      </p>
-                      <p style="margin: 15px 0 0 0; color: #333; font-size: 16px; line-height: 1.6;">
-                        <span style="color: #4a5568;">Password:</span> 
-                        <strong style="color: #2d3748;">%s</strong>
-                      </p>
-                    </td>
-                  </tr>
-                </table>
-              </td>
-            </tr>
-
-            <!-- Login Button -->
-            <tr>
-              <td align="center" style="padding: 30px 40px;">
-                <a href="https://www.officetimer.com/login/" style="background-color: #2b6cb0; color: white; padding: 12px 30px; text-decoration: none; border-radius: 6px; font-weight: bold; display: inline-block;">Access OfficeTimer</a>
-              </td>
-            </tr>
-
-            <!-- Divider -->
-            <tr>
-              <td align="center" style="padding: 0 40px;">
-                <div style="height: 1px; background-color: #edf2f7;"></div>
-              </td>
-            </tr>
-
-            <!-- Footer Section -->
-            <tr>
-              <td align="center" style="padding: 30px 40px;">
-                <p style="margin: 0; color: #4a5568; font-size: 14px; line-height: 1.6;">
-                  <em style="color: #2d3748;">%s</em><br>
-                  <span style="color: #4a5568;">Knowles IT Monitoring Team</span><br>
-                  Email: <a href="mailto:%s" style="color: #2b6cb0; text-decoration: none;">%s</a><br>
-                  Visit us: <a href="https://www.philippines.knowlesti.com" style="color: #2b6cb0; text-decoration: none;">philippines.knowlesti.com</a>
-                </p>
-              </td>
-            </tr>
-          </table>
-        </td>
-      </tr>
-    </table>
-  </body>
-  </html>
-  `
-
-	// Late template
-	Late Template = `
-    <!DOCTYPE html>
-  <html>
-  <head>
-    <meta charset="utf-8">
-    <title>Important Reminder for Late Interns</title>
-  </head>
-  <body style="margin: 0; padding: 15px; background-color: #e9f1f7; font-family: Arial, sans-serif;">
-    <table role="presentation" width="100%%" height="100%%" cellspacing="0" cellpadding="0" border="0">
-      <tr>
-        <td align="center" valign="middle">
-          <table role="presentation" width="600" cellspacing="0" cellpadding="0" border="0" style="background-color: white; border-radius: 10px; box-shadow: 0 4px 6px rgba(0, 0, 0, 0.1), 0 10px 15px rgba(0, 0, 0, 0.05);">
-            <!-- Logo Section -->
-            <tr>
-              <td align="center" valign="middle" style="padding: 20px 20px;">
-                <a href="https://www.knowlesti.sg" target="_blank" style="display: inline-block;">
-                  <img src="https://i.imgur.com/Q9nLEZA.png" width="200" alt="Knowles Training Institute" style="border: 0; display: block;">
-                </a>
-              </td>
-            </tr>
-
-            <!-- Divider -->
-            <tr>
-              <td align="center" style="padding: 0px 40px;">
-                <div style="height: 1px; background-color: #edf2f7;"></div>
-              </td>
-            </tr>
-
-            <!-- Title Section -->
-            <tr>
-              <td align="center" style="padding: 20px 40px 0;">
-                <h1 style="color: #1a365d; font-size: 24px; margin: 0; font-family: Arial, sans-serif;">Important Reminder for Late Interns</h1>
-                <p style="color: #4a5568; font-size: 16px; padding: 10px; margin: 0; font-family: Arial, sans-serif; text-align: justify;">
-                <br><br>
-                Dear Intern,<br><br>
-                We hope this message finds you well. As you know, punctuality is an essential aspect of professionalism and contributes significantly to the success of any workplace. We understand that unforeseen circumstances may sometimes cause delays, but it is crucial to prioritize timeliness in your internship experience. 
-                <br><br>
-                We kindly remind all interns who have been late to take this matter seriously and make the necessary adjustments to ensure your punctuality moving forward. Remember, being on time not only demonstrates your commitment and respect for your work but also allows you to maximize your learning opportunities and contribute effectively to the team. 
-                <br><br>
-                To help you improve your punctuality, we suggest the following:
-                </p>
-                <ul style="color: #4a5568; font-size: 16px; padding: 10px; margin: 0; font-family: Arial, sans-serif; text-align: justify;">
-                <li><strong>Plan ahead:</strong> Set your alarm clock early enough to provide ample time for your morning routine and commute. Consider any potential traffic or public transportation delays.</li>
-                <br><br>
-                <li><strong>Prepare in advance:</strong> Organize your essentials, such as your work bag and necessary documents, the night before to avoid last-minute rushes or forgotten items.</li>
-                <br><br>
-                <li><strong>Communicate proactively:</strong> If you encounter an unexpected situation that may cause tardiness, immediately notify your supervisor or the appropriate person. Prompt communication demonstrates responsibility and enables your team to plan accordingly.</li>
-                <br><br>
-                <li><strong>Seek support:</strong> If you struggle with punctuality, don't hesitate to seek guidance from your mentor, supervisor, or colleagues. They can provide valuable advice or resources to help you manage your time effectively.</li>
-                </ul>
-                <p style="color: #4a5568; font-size: 16px; padding: 10px; margin: 0; font-family: Arial, sans-serif; text-align: justify;">
-                Please remember that your time with us is a valuable learning experience, and developing strong professional habits, such as punctuality, will greatly benefit your future career endeavors. 
-                <br><br>
-                We believe in your potential and are confident that you can make the necessary adjustments to improve your timeliness. If you have any questions or need further assistance, don't hesitate to reach out to your supervisor or the intern coordinator. 
-                <br><br>
-                Thank you for your attention, and we look forward to your continued growth and success during your internship. 
-                <br><br>
-                Best regards,<br>
-                Monitoring Team<br>
-                </p>
-              </td>
-            </tr>
-
-            <!-- Divider -->
-            <tr>
-              <td align="center" style="padding: 0 40px;">
-                <div style="height: 1px; background-color: #edf2f7;"></div>
-              </td>
-            </tr>
-
-            <!-- Footer Section -->
-            <tr>
-              <td align="center" style="padding: 30px 40px;">
-                <p style="margin: 0; color: #4a5568; font-size: 14px; line-height: 1.6;">
-                  <em style="color: #2d3748;">%s</em><br>
-                  <span style="color: #4a5568;">Knowles IT Monitoring Team</span><br>
-                  Email: <a href="mailto:%s" style="color: #2b6cb0; text-decoration: none;">%s</a><br>
-                  Visit us: <a href="https://www.philippines.knowlesti.com" style="color: #2b6cb0; text-decoration: none;">philippines.knowlesti.com</a>
-                </p>
-              </td>
-            </tr>
-          </table>
-        </td>
-      </tr>
-    </table>
-  </body>
-  </html>
-  `
-)
+// Deletes the password link of an email that won't be sent.
+func (e Email) removePasswordLink() {
+	if e.passwordLink == "" {
+		return
+	}
+	if err := e.Config.SecretLinks.Remove(e.passwordLink); err != nil {
+		logger.Warn("failed to remove secret link", "recipient", e.To.Email, "error", err)
+		return
+	}
+	logger.Info("secret link removed", "recipient", e.To.Email)
+}
+
+func (e Email) password() string {
+	if e.To.Password != "" {
+		return e.To.Password
+	}
+	return defaultPassword
+}
+
+// Returns the password as it's shown in the email: as it is, or as a
+// one-time link to it. Previews and test emails show a link that
+// doesn't work.
+func (e Email) passwordHTML() string {
+	links := e.Config.SecretLinks
+	if links == nil || !e.Body.SecretPassword {
+		return html.EscapeString(e.password())
+	}
+
+	link, expires := e.passwordLink, e.linkExpires
+	if link == "" {
+		link, expires = links.BaseURL+"/s/preview", time.Now().Add(links.TTL)
+	}
+
+	locale := e.locale()
+	expiry := formatDate(expires, translate(locale, "January 2, 2006 at 3:04 PM MST"), locale)
+	return fmt.Sprintf(`<a href="%s" style="color: #2b6cb0;">%s</a><br>`+
+		`<span style="color: #718096; font-size: 13px; font-weight: normal;">%s</span>`,
+		html.EscapeString(link), html.EscapeString(translate(locale, "View your password")),
+		html.EscapeString(fmt.Sprintf(translate(locale, "The link works once and expires on %s."), expiry)))
+}
@@ -0,0 +1,146 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	sendLockFile   = "send.lock"   // Held by the process sending the job
+	updateLockFile = "update.lock" // Held while the job file is read and rewritten
+)
+
+// How long a lock can go without being refreshed before it's taken to
+// have been left behind by a process that died.
+const (
+	sendLockStale   = 2 * time.Minute
+	updateLockStale = 10 * time.Second
+)
+
+// Returned when another process is already sending a job.
+var ErrJobLocked = errors.New("being sent by another process")
+
+// Creates the lock file, and reports false if another process holds it.
+// A lock file older than stale is removed and taken over.
+func createLock(path string, stale time.Duration) (bool, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			return true, f.Close()
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return false, err
+		}
+
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if time.Since(info.ModTime()) < stale {
+			return false, nil
+		}
+
+		logger.Warn("stale lock removed", "path", path, "modified", info.ModTime())
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, err
+		}
+	}
+}
+
+// Refreshes the send lock at path until the returned function is
+// called, which removes it.
+func holdLock(path string) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(sendLockStale / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				os.Chtimes(path, now, now)
+			}
+		}
+	}()
+
+	// Copies of the job share the function, so it may be called twice
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+			os.Remove(path)
+		})
+	}
+}
+
+// Marks the job as being sent by this process until Unlock is called,
+// so that neither the scheduler nor resume sends it at the same time.
+// It returns ErrJobLocked if another process is sending it.
+func (j *Job) Lock() error {
+	if j.unlock != nil {
+		return nil
+	}
+
+	path := filepath.Join(QueueDir(), j.ID, sendLockFile)
+	ok, err := createLock(path, sendLockStale)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("job %s is %w", j.ID, ErrJobLocked)
+	}
+
+	j.unlock = holdLock(path)
+	return nil
+}
+
+// Lets other processes send the job again.
+func (j *Job) Unlock() {
+	if j.unlock != nil {
+		j.unlock()
+		j.unlock = nil
+	}
+}
+
+// Re-reads the job from disk, changes it and writes it back while
+// holding the job's update lock, so that the changes of another process
+// aren't overwritten. Nothing is written if change fails.
+func updateJob(id string, change func(*Job) error) (Job, error) {
+	path := filepath.Join(QueueDir(), id, updateLockFile)
+	for {
+		ok, err := createLock(path, updateLockStale)
+		if err != nil {
+			return Job{}, fmt.Errorf("failed to lock job %s: %w", id, err)
+		}
+		if ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer os.Remove(path)
+
+	job, err := readJob(id)
+	if err != nil {
+		return Job{}, err
+	}
+	if err := change(&job); err != nil {
+		return job, err
+	}
+
+	return job, writeJob(job)
+}
@@ -0,0 +1,125 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/vanng822/go-premailer/premailer"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	goldmarkhtml "github.com/yuin/goldmark/renderer/html"
+)
+
+// The shared layout, its partials and the content of the built-in
+// templates.
+//
+//go:embed templates
+var templateFiles embed.FS
+
+// The layout every template with Content is put in. It renders the
+// "content" template between the "header" and "footer" partials, and
+// its stylesheet is inlined into the HTML once it's rendered, since
+// many mail clients ignore <style>.
+var layout = templateFile("layout.html") + templateFile("partials.html")
+
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.Table, extension.Strikethrough),
+	goldmark.WithRendererOptions(goldmarkhtml.WithUnsafe()), // Allow HTML in Markdown
+)
+
+// Template actions, kept out of the way of the Markdown converter.
+var (
+	actionPattern = regexp.MustCompile(`(?s)\{\{.*?\}\}`)
+	actionLine    = regexp.MustCompile(`(?m)^[ \t]*(TMPLACTION\d+X)[ \t]*$`)
+	actionToken   = regexp.MustCompile(`(?:<!--)?TMPLACTION(\d+)X(?:-->)?`)
+)
+
+func templateFile(name string) string {
+	data, err := templateFiles.ReadFile("templates/" + name)
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
+
+// Parses the template's body: its Body as is, or its Content in the
+// layout.
+func (t Template) parse(funcs map[string]any) (*template.Template, error) {
+	if !t.usesLayout() {
+		return template.New(t.Name).Funcs(funcs).Option("missingkey=error").Parse(t.Body)
+	}
+
+	content, err := t.contentHTML()
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.New(t.Name).Funcs(funcs).Option("missingkey=error").Parse(layout)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmpl.New("content").Parse(content); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// Returns the template's Content as HTML, with its template actions.
+func (t Template) contentHTML() (string, error) {
+	if !t.Markdown {
+		return t.Content, nil
+	}
+
+	// Actions are swapped for plain words so the converter leaves them
+	// alone. An action on a line of its own is kept out of paragraphs,
+	// since it usually renders a block, such as a button.
+	actions := actionPattern.FindAllString(t.Content, -1)
+	i := 0
+	source := actionPattern.ReplaceAllStringFunc(t.Content, func(string) string {
+		i++
+		return fmt.Sprintf("TMPLACTION%dX", i-1)
+	})
+	source = actionLine.ReplaceAllString(source, "<!--$1-->")
+
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(source), &buf); err != nil {
+		return "", fmt.Errorf("invalid Markdown: %w", err)
+	}
+
+	return actionToken.ReplaceAllStringFunc(buf.String(), func(token string) string {
+		n, _ := strconv.Atoi(actionToken.FindStringSubmatch(token)[1])
+		return actions[n]
+	}), nil
+}
+
+// Moves the stylesheet of the rendered HTML into style attributes.
+func inlineCSS(body string) (string, error) {
+	p, err := premailer.NewPremailerFromString(body, premailer.NewOptions())
+	if err != nil {
+		return "", fmt.Errorf("failed to inline CSS: %w", err)
+	}
+
+	inlined, err := p.Transform()
+	if err != nil {
+		return "", fmt.Errorf("failed to inline CSS: %w", err)
+	}
+	return inlined, nil
+}
+
+// Renders a link as a button, e.g. {{button "Log in" "https://..."}}.
+func button(text, url string) template.HTML {
+	return template.HTML(fmt.Sprintf(`<div class="actions" align="center"><a href="%s" class="button">%s</a></div>`,
+		template.HTMLEscapeString(url), template.HTMLEscapeString(text)))
+}
+
+// Reports whether the body is made from Content in the layout.
+func (t Template) usesLayout() bool {
+	return strings.TrimSpace(t.Content) != ""
+}
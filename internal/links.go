@@ -0,0 +1,383 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrLinkNotFound = errors.New("link already used or never existed")
+	ErrLinkExpired  = errors.New("link expired")
+)
+
+// One-time links that show a secret, such as a password, once. Secrets
+// are kept as files in Dir, each encrypted with a key derived from its
+// link, so the files alone don't give them away.
+type SecretLinks struct {
+	BaseURL string        // Where serve-secrets can be reached, e.g. https://secrets.example.com
+	Dir     string        // Where the secrets are kept until viewed
+	TTL     time.Duration // How long a link works if it isn't opened
+
+	// Proxies whose X-Forwarded-For and X-Real-IP headers are believed
+	TrustedProxies []netip.Prefix
+}
+
+type storedSecret struct {
+	Recipient string
+	Created   time.Time
+	Expires   time.Time
+	Nonce     []byte
+	Data      []byte
+}
+
+// Returns the one-time link settings, or nil if SECRET_LINKS_URL isn't
+// set and passwords are put in emails as they are.
+func LoadSecretLinks() (*SecretLinks, error) {
+	baseURL := os.Getenv("SECRET_LINKS_URL")
+	if baseURL == "" {
+		return nil, nil
+	}
+
+	hours, err := strconv.Atoi(getenv("SECRET_LINKS_TTL_HOURS", "72"))
+	if err != nil || hours <= 0 {
+		return nil, fmt.Errorf("SECRET_LINKS_TTL_HOURS must be a whole number of hours")
+	}
+
+	proxies, err := parseProxies(getenv("SECRET_LINKS_TRUSTED_PROXIES", "127.0.0.1,::1"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretLinks{
+		BaseURL:        strings.TrimRight(baseURL, "/"),
+		Dir:            getenv("SECRET_LINKS_DIR", "secrets"),
+		TTL:            time.Duration(hours) * time.Hour,
+		TrustedProxies: proxies,
+	}, nil
+}
+
+// Parses a comma-separated list of IP addresses and CIDR ranges.
+func parseProxies(list string) ([]netip.Prefix, error) {
+	proxies := []netip.Prefix{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if addr, err := netip.ParseAddr(item); err == nil {
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q in SECRET_LINKS_TRUSTED_PROXIES", item)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+func (l *SecretLinks) trusted(addr netip.Addr) bool {
+	for _, prefix := range l.TrustedProxies {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the address of the client that made the request. Behind a
+// trusted proxy it's the last address in X-Forwarded-For that isn't one
+// of the trusted proxies, or else X-Real-IP. Anyone else's headers are
+// ignored, since the client could have set them.
+func (l *SecretLinks) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote, err := netip.ParseAddr(host)
+	if err != nil || !l.trusted(remote) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		if !l.trusted(addr) {
+			return addr.Unmap().String()
+		}
+	}
+
+	if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return addr.Unmap().String()
+	}
+	return host
+}
+
+// Stores the secret for the recipient and returns the link that shows
+// it, and when the link expires. The link works for TTL from start,
+// when the email is sent.
+func (l *SecretLinks) Create(secret, recipient string, start time.Time) (string, time.Time, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	gcm, err := linkCipher(token)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	stored := storedSecret{
+		Recipient: recipient,
+		Created:   now,
+		Expires:   start.Add(l.TTL),
+		Nonce:     make([]byte, gcm.NonceSize()),
+	}
+	if _, err := rand.Read(stored.Nonce); err != nil {
+		return "", time.Time{}, err
+	}
+	stored.Data = gcm.Seal(nil, stored.Nonce, []byte(secret), nil)
+
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if err := writeFile(l.path(token), data); err != nil {
+		return "", time.Time{}, err
+	}
+
+	logger.Info("secret link created", "recipient", recipient, "expires", stored.Expires)
+	return l.BaseURL + "/s/" + token, stored.Expires, nil
+}
+
+// Deletes the secret behind a link that won't be sent after all.
+func (l *SecretLinks) Remove(link string) error {
+	token, ok := strings.CutPrefix(link, l.BaseURL+"/s/")
+	if !ok {
+		return fmt.Errorf("not a secret link: %s", link)
+	}
+
+	if err := os.Remove(l.path(token)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Returns the secret behind the link and deletes it, so it can't be
+// shown again.
+func (l *SecretLinks) Reveal(token string) (string, string, error) {
+	path := l.path(token)
+
+	// Only one of two requests at the same time can claim the file
+	claimed := path + ".opened"
+	if err := os.Rename(path, claimed); err != nil {
+		return "", "", ErrLinkNotFound
+	}
+	defer os.Remove(claimed)
+
+	data, err := os.ReadFile(claimed)
+	if err != nil {
+		return "", "", err
+	}
+
+	var stored storedSecret
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return "", "", err
+	}
+
+	if time.Now().After(stored.Expires) {
+		return "", stored.Recipient, ErrLinkExpired
+	}
+
+	gcm, err := linkCipher(token)
+	if err != nil {
+		return "", "", err
+	}
+
+	secret, err := gcm.Open(nil, stored.Nonce, stored.Data, nil)
+	if err != nil {
+		return "", "", ErrLinkNotFound
+	}
+
+	return string(secret), stored.Recipient, nil
+}
+
+// Reports whether the link can still be opened.
+func (l *SecretLinks) Valid(token string) bool {
+	data, err := os.ReadFile(l.path(token))
+	if err != nil {
+		return false
+	}
+
+	var stored storedSecret
+	return json.Unmarshal(data, &stored) == nil && time.Now().Before(stored.Expires)
+}
+
+// Deletes the secrets whose links have expired.
+func (l *SecretLinks) Clean() error {
+	entries, err := os.ReadDir(l.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(l.Dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		var stored storedSecret
+		if json.Unmarshal(data, &stored) == nil && time.Now().After(stored.Expires) {
+			os.Remove(path)
+			logger.Info("secret link expired", "recipient", stored.Recipient, "expired", stored.Expires)
+		}
+	}
+
+	return nil
+}
+
+// The file of a link's secret is named after a hash of the link, and
+// encrypted with a key from another hash of it.
+func (l *SecretLinks) path(token string) string {
+	sum := sha256.Sum256([]byte("id:" + token))
+	return filepath.Join(l.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func linkCipher(token string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte("key:" + token))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Serves the links on addr until ctx is done. Expired secrets are
+// deleted every hour.
+func (l *SecretLinks) Serve(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: l.handler(), ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			l.Clean()
+			select {
+			case <-ctx.Done():
+				server.Close()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (l *SecretLinks) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	// Opening the link only asks to reveal the secret, since mail
+	// scanners open links in emails before the recipient does
+	mux.HandleFunc("GET /s/{token}", func(w http.ResponseWriter, r *http.Request) {
+		noStore(w)
+		if !l.Valid(r.PathValue("token")) {
+			linkGone(w)
+			return
+		}
+		renderPage(w, secretPage, map[string]any{})
+	})
+
+	mux.HandleFunc("POST /s/{token}", func(w http.ResponseWriter, r *http.Request) {
+		noStore(w)
+		secret, recipient, err := l.Reveal(r.PathValue("token"))
+		if err != nil {
+			if errors.Is(err, ErrLinkExpired) {
+				logger.Warn("secret link opened after expiring", "recipient", recipient, "ip", l.clientIP(r))
+			}
+			linkGone(w)
+			return
+		}
+
+		logger.Info("secret viewed", "recipient", recipient, "ip", l.clientIP(r), "user_agent", r.UserAgent())
+		renderPage(w, secretPage, map[string]any{"Secret": secret})
+	})
+
+	return mux
+}
+
+// Keeps the pages out of caches, and their links out of the Referer
+// header of any request made from them.
+func noStore(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+}
+
+func linkGone(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	secretPage.Execute(w, map[string]any{"Gone": true})
+}
+
+var secretPage = template.Must(template.New("secret").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="robots" content="noindex">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Your password</title>
+<style>
+body { font-family: Arial, sans-serif; background: #e9f1f7; margin: 0; padding: 40px 15px; color: #2d3748; }
+main { max-width: 480px; margin: auto; background: white; border-radius: 10px; padding: 30px; text-align: center; }
+button { background: #2b6cb0; color: white; border: 0; padding: 12px 30px; border-radius: 6px; font-weight: bold; font-size: 16px; cursor: pointer; }
+code { display: block; font-size: 22px; background: #f8fafc; border: 1px solid #e2e8f0; border-radius: 8px; padding: 15px; margin: 20px 0; user-select: all; }
+</style></head>
+<body><main>
+{{if .Gone}}
+<h1>This link no longer works</h1>
+<p>It has already been opened or has expired. Ask the sender for a new one.</p>
+{{else if .Secret}}
+<h1>Your password</h1>
+<code>{{.Secret}}</code>
+<p>Copy it now. It won't be shown again.</p>
+{{else}}
+<h1>Your password</h1>
+<p>The password can only be shown once.</p>
+<form method="post"><button type="submit">Show my password</button></form>
+{{end}}
+</main></body></html>`))
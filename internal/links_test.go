@@ -0,0 +1,149 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecretLinkPages(t *testing.T) {
+	links := &SecretLinks{BaseURL: "https://secrets.example.com", Dir: t.TempDir(), TTL: time.Hour}
+	link, _, err := links.Create("hunter2", "jane@example.com", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := strings.TrimPrefix(link, links.BaseURL)
+
+	serve := func(method string) (*http.Response, string) {
+		w := httptest.NewRecorder()
+		links.handler().ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		body, _ := io.ReadAll(w.Result().Body)
+		return w.Result(), string(body)
+	}
+
+	for _, step := range []struct {
+		method string
+		status int
+		shows  string
+	}{
+		// Opening the link doesn't use it up
+		{http.MethodGet, http.StatusOK, "Show my password"},
+		{http.MethodGet, http.StatusOK, "Show my password"},
+		{http.MethodPost, http.StatusOK, "hunter2"},
+		{http.MethodPost, http.StatusNotFound, "no longer works"},
+		{http.MethodGet, http.StatusNotFound, "no longer works"},
+	} {
+		resp, body := serve(step.method)
+		if resp.StatusCode != step.status || !strings.Contains(body, step.shows) {
+			t.Errorf("%s = %d, want %d showing %q", step.method, resp.StatusCode, step.status, step.shows)
+		}
+		if cache := resp.Header.Get("Cache-Control"); cache != "no-store" {
+			t.Errorf("%s Cache-Control = %q, want no-store", step.method, cache)
+		}
+		if referrer := resp.Header.Get("Referrer-Policy"); referrer != "no-referrer" {
+			t.Errorf("%s Referrer-Policy = %q, want no-referrer", step.method, referrer)
+		}
+	}
+}
+
+func TestPasswordLinks(t *testing.T) {
+	links := &SecretLinks{BaseURL: "https://secrets.example.com", Dir: t.TempDir(), TTL: time.Hour}
+	config := testConfig()
+	config.SecretLinks = links
+	em := Email{Body: Credentials, To: User{Name: "Jane Doe", Email: "jane@example.com", Password: "hunter2"}, Config: config}
+
+	sent := func(transport *MemoryTransport) (string, int) {
+		t.Helper()
+		messages := transport.Messages()
+		files, err := os.ReadDir(links.Dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.ReplaceAll(string(messages[len(messages)-1].Data), "=\r\n", ""), len(files)
+	}
+
+	// Test emails get the preview link, never one to the real password
+	transport := &MemoryTransport{}
+	if err := em.SendTest(transport, User{Email: "tester@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if data, files := sent(transport); files != 0 || !strings.Contains(data, links.BaseURL+"/s/preview") {
+		t.Errorf("test email created %d links, want only the preview link", files)
+	}
+
+	if err := em.Send(transport); err != nil {
+		t.Fatal(err)
+	}
+	if data, files := sent(transport); files != 1 || strings.Contains(data, "/s/preview") || strings.Contains(data, "hunter2") {
+		t.Errorf("email created %d links, want one link instead of the password", files)
+	}
+
+	// Templates without a secret password show it as it is
+	em.Body = Template{Name: "PLAIN", Subject: "Hi", Body: "{{.Password}}"}
+	if err := em.Send(transport); err != nil {
+		t.Fatal(err)
+	}
+	if data, files := sent(transport); files != 1 || !strings.Contains(data, "hunter2") {
+		t.Errorf("plain email created %d more links, want the password as it is", files-1)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseProxies("127.0.0.1, ::1, 10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := &SecretLinks{TrustedProxies: proxies}
+
+	for name, test := range map[string]struct {
+		remote    string
+		forwarded []string
+		realIP    string
+		want      string
+	}{
+		"direct":                  {"203.0.113.7:5000", nil, "", "203.0.113.7"},
+		"untrusted forwarding":    {"203.0.113.7:5000", []string{"198.51.100.1"}, "198.51.100.2", "203.0.113.7"},
+		"trusted proxy":           {"127.0.0.1:5000", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		"chain of proxies":        {"10.0.0.2:5000", []string{"198.51.100.1, 10.0.0.3"}, "", "198.51.100.1"},
+		"spoofed by the client":   {"127.0.0.1:5000", []string{"1.2.3.4, 198.51.100.1"}, "", "198.51.100.1"},
+		"several headers":         {"[::1]:5000", []string{"1.2.3.4", "198.51.100.1"}, "", "198.51.100.1"},
+		"real ip":                 {"127.0.0.1:5000", nil, "198.51.100.1", "198.51.100.1"},
+		"garbage":                 {"127.0.0.1:5000", []string{"unknown"}, "", "127.0.0.1"},
+		"only proxies":            {"127.0.0.1:5000", []string{"10.0.0.3"}, "", "127.0.0.1"},
+		"ipv4 mapped proxy":       {"[::ffff:127.0.0.1]:5000", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		"forwarded ipv6":          {"127.0.0.1:5000", []string{"2001:db8::1"}, "", "2001:db8::1"},
+		"forwarding over realip":  {"127.0.0.1:5000", []string{"198.51.100.1"}, "198.51.100.2", "198.51.100.1"},
+		"no trusted proxies here": {"192.0.2.1:5000", []string{"198.51.100.1"}, "", "192.0.2.1"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/s/token", nil)
+			r.RemoteAddr = test.remote
+			for _, value := range test.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if test.realIP != "" {
+				r.Header.Set("X-Real-IP", test.realIP)
+			}
+
+			if got := links.clientIP(r); got != test.want {
+				t.Errorf("clientIP = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseProxies(t *testing.T) {
+	if _, err := parseProxies("127.0.0.1, proxy.example.com"); err == nil {
+		t.Error("a host name was accepted as a proxy")
+	}
+	proxies, err := parseProxies("")
+	if err != nil || len(proxies) != 0 {
+		t.Errorf("parseProxies(\"\") = %v, %v, want none", proxies, err)
+	}
+}
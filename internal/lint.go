@@ -0,0 +1,496 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"golang.org/x/net/html"
+)
+
+// A problem LintTemplates found in a template.
+type LintProblem struct {
+	Template string
+	Part     string // subject or body
+	Line     int    // 0 if it's about the whole part
+	Message  string
+	Warn     bool // The email works, but should be fixed
+}
+
+func (p LintProblem) String() string {
+	if p.Template == "" {
+		return p.Message
+	}
+	return p.Location() + ": " + p.Message
+}
+
+// Returns where the problem is, e.g. "CRED body:12".
+func (p LintProblem) Location() string {
+	location := p.Template + " " + p.Part
+	if p.Line > 0 {
+		location += fmt.Sprintf(":%d", p.Line)
+	}
+	return location
+}
+
+// Elements that have no end tag.
+var voidElements = []string{"area", "base", "br", "col", "embed", "hr", "img", "input", "link", "meta", "param", "source", "track", "wbr"}
+
+// Checks the templates for mistakes that would otherwise show up in
+// sent emails: syntax errors, fields that don't exist, broken HTML, and
+// images and links that don't work well in emails.
+//
+// Variables are checked against vars and To.Fields against columns,
+// unless they're nil. Variables that no template uses are reported too.
+func LintTemplates(templates []Template, vars map[string]string, columns []string) []LintProblem {
+	problems := []LintProblem{}
+	used := map[string]bool{}
+
+	for _, t := range localizedTemplates(templates) {
+		l := linter{template: t, vars: vars, usedVars: used}
+		if columns != nil {
+			l.columns = map[string]bool{}
+			for _, column := range columns {
+				l.columns[column] = true
+			}
+		}
+
+		l.part = "subject"
+		if strings.TrimSpace(t.Subject) == "" {
+			l.report(noPos, false, "the template has no subject")
+		} else {
+			l.lint(t.Subject)
+		}
+
+		// Templates in the layout are checked without it
+		l.part = "body"
+		body := t.Body
+		if t.usesLayout() {
+			body = t.Content
+		}
+		if l.lint(body) {
+			l.checkEscaping()
+			l.checkHTML()
+		}
+		// Translations share the template's images
+		if !strings.Contains(t.Name, ".") {
+			l.checkImages()
+		}
+
+		// The subject's problems first, then the body's, by line
+		slices.SortStableFunc(l.problems, func(a, b LintProblem) int {
+			if a.Part != b.Part {
+				return strings.Compare(b.Part, a.Part)
+			}
+			return cmp.Compare(a.Line, b.Line)
+		})
+		problems = append(problems, l.problems...)
+	}
+
+	if vars != nil {
+		for _, name := range slices.Sorted(maps.Keys(vars)) {
+			if !used[name] {
+				problems = append(problems, LintProblem{Message: fmt.Sprintf("variable %s is set but no template uses it", name), Warn: true})
+			}
+		}
+	}
+
+	return problems
+}
+
+// Returns the templates with each of their translations after them, as
+// templates of their own named like LATE.fil.
+func localizedTemplates(templates []Template) []Template {
+	all := []Template{}
+	for _, t := range templates {
+		all = append(all, t)
+		for _, locale := range slices.Sorted(maps.Keys(t.Translations)) {
+			localized, _ := t.Localize(locale)
+			localized.Name += "." + locale
+			all = append(all, localized)
+		}
+	}
+	return all
+}
+
+// What dot, or a variable, refers to while walking a template.
+type dotState struct {
+	typ  reflect.Type // nil if unknown
+	path string       // e.g. To.Fields, to know whose keys a map has
+}
+
+var rootState = dotState{typ: reflect.TypeOf(TemplateContext{})}
+
+type linter struct {
+	template Template
+	part     string
+	source   string
+	vars     map[string]string
+	columns  map[string]bool
+	usedVars map[string]bool
+	problems []LintProblem
+
+	declared map[string]dotState
+	declPos  map[string]parse.Pos
+	usedDecl map[string]bool
+}
+
+// Position of problems about the whole subject or body.
+const noPos parse.Pos = -1
+
+func (l *linter) report(pos parse.Pos, warn bool, format string, args ...any) {
+	line := 0
+	if pos != noPos {
+		line = 1 + strings.Count(l.source[:min(int(pos), len(l.source))], "\n")
+	}
+
+	l.problems = append(l.problems, LintProblem{
+		Template: l.template.Name,
+		Part:     l.part,
+		Line:     line,
+		Message:  fmt.Sprintf(format, args...),
+		Warn:     warn,
+	})
+}
+
+// Parses the source and checks what it refers to. Reports whether it
+// parsed, since nothing else can be checked otherwise.
+func (l *linter) lint(source string) bool {
+	l.source = source
+	l.declared, l.declPos, l.usedDecl = map[string]dotState{}, map[string]parse.Pos{}, map[string]bool{}
+
+	tmpl, err := template.New(l.template.Name).Funcs(Email{}.funcs()).Parse(source)
+	if err != nil {
+		l.problems = append(l.problems, LintProblem{Template: l.template.Name, Part: l.part, Message: strings.TrimPrefix(err.Error(), "template: ")})
+		return false
+	}
+
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			l.walk(t.Tree.Root, rootState)
+		}
+	}
+
+	for name, pos := range l.declPos {
+		if !l.usedDecl[name] {
+			l.report(pos, true, "%s is set but never used", name)
+		}
+	}
+
+	return true
+}
+
+func (l *linter) walk(node parse.Node, dot dotState) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			l.walk(child, dot)
+		}
+	case *parse.ActionNode:
+		l.pipe(n.Pipe, dot)
+	case *parse.IfNode:
+		l.pipe(n.Pipe, dot)
+		l.walk(n.List, dot)
+		l.walk(n.ElseList, dot)
+	case *parse.WithNode:
+		inner := l.pipe(n.Pipe, dot)
+		l.walk(n.List, inner)
+		l.walk(n.ElseList, dot)
+	case *parse.RangeNode:
+		over := l.pipe(n.Pipe, dot)
+		key, elem := dotState{}, dotState{}
+		if t := over.typ; t != nil {
+			switch t.Kind() {
+			case reflect.Map:
+				key, elem = dotState{typ: t.Key()}, dotState{typ: t.Elem()}
+			case reflect.Slice, reflect.Array:
+				key, elem = dotState{typ: reflect.TypeOf(0)}, dotState{typ: t.Elem()}
+			}
+		}
+
+		// {{range $i, $e := ...}} needs $i to get $e
+		switch len(n.Pipe.Decl) {
+		case 1:
+			l.declared[n.Pipe.Decl[0].Ident[0]] = elem
+		case 2:
+			l.declared[n.Pipe.Decl[0].Ident[0]] = key
+			l.declared[n.Pipe.Decl[1].Ident[0]] = elem
+			l.usedDecl[n.Pipe.Decl[0].Ident[0]] = true
+		}
+
+		l.walk(n.List, elem)
+		l.walk(n.ElseList, dot)
+	case *parse.TemplateNode:
+		l.pipe(n.Pipe, dot)
+	}
+}
+
+// Checks a pipeline and returns what it evaluates to.
+func (l *linter) pipe(p *parse.PipeNode, dot dotState) dotState {
+	if p == nil {
+		return dotState{}
+	}
+
+	result := dotState{}
+	for _, cmd := range p.Cmds {
+		result = l.command(cmd, dot)
+	}
+
+	if !p.IsAssign {
+		for _, v := range p.Decl {
+			l.declared[v.Ident[0]] = result
+			l.declPos[v.Ident[0]] = v.Position()
+		}
+	}
+
+	return result
+}
+
+func (l *linter) command(cmd *parse.CommandNode, dot dotState) dotState {
+	if len(cmd.Args) == 0 {
+		return dotState{}
+	}
+
+	args := []dotState{}
+	for _, arg := range cmd.Args[1:] {
+		args = append(args, l.arg(arg, dot))
+	}
+
+	fn, ok := cmd.Args[0].(*parse.IdentifierNode)
+	if !ok {
+		return l.arg(cmd.Args[0], dot)
+	}
+
+	// {{index .To.Fields "Start Date"}}
+	if fn.Ident == "index" && len(cmd.Args) == 3 {
+		if key, ok := cmd.Args[2].(*parse.StringNode); ok {
+			l.key(args[0].path, key.Text, key.Position())
+		}
+		if t := args[0].typ; t != nil && (t.Kind() == reflect.Map || t.Kind() == reflect.Slice) {
+			return dotState{typ: t.Elem()}
+		}
+		return dotState{}
+	}
+
+	if f, ok := (Email{}).funcs()[fn.Ident]; ok {
+		if t := reflect.TypeOf(f); t.NumOut() > 0 {
+			return dotState{typ: t.Out(0)}
+		}
+	}
+	return dotState{}
+}
+
+func (l *linter) arg(node parse.Node, dot dotState) dotState {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.FieldNode:
+		return l.fields(dot, n.Ident, n.Position())
+	case *parse.ChainNode:
+		return l.fields(l.arg(n.Node, dot), n.Field, n.Position())
+	case *parse.VariableNode:
+		name := n.Ident[0]
+		l.usedDecl[name] = true
+
+		base := rootState
+		if name != "$" {
+			base = l.declared[name]
+		}
+		return l.fields(base, n.Ident[1:], n.Position())
+	case *parse.PipeNode:
+		return l.pipe(n, dot)
+	}
+	return dotState{}
+}
+
+// Follows the fields from s, reporting the first that doesn't exist.
+func (l *linter) fields(s dotState, names []string, pos parse.Pos) dotState {
+	for _, name := range names {
+		t := s.typ
+		if t == nil {
+			return dotState{}
+		}
+
+		if m, ok := t.MethodByName(name); ok && m.Type.NumOut() > 0 {
+			s = dotState{typ: m.Type.Out(0)}
+			continue
+		}
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+
+		path := name
+		if s.path != "" {
+			path = s.path + "." + name
+		}
+
+		switch t.Kind() {
+		case reflect.Struct:
+			f, ok := t.FieldByName(name)
+			if !ok || !f.IsExported() {
+				l.report(pos, false, "undefined field .%s", path)
+				return dotState{}
+			}
+			s = dotState{typ: f.Type, path: path}
+		case reflect.Map:
+			l.key(s.path, name, pos)
+			s = dotState{typ: t.Elem()}
+		default:
+			l.report(pos, false, "%s has no field %s", t, name)
+			return dotState{}
+		}
+	}
+	return s
+}
+
+// Checks a key of .Vars or .To.Fields.
+func (l *linter) key(path, name string, pos parse.Pos) {
+	switch path {
+	case "Vars":
+		l.usedVars[name] = true
+		if _, ok := l.vars[name]; l.vars != nil && !ok {
+			l.report(pos, false, "variable %s isn't set, set it with -var %s=...", name, name)
+		}
+	case "To.Fields":
+		if l.columns != nil && !l.columns[name] {
+			l.report(pos, false, "column %q isn't in the input file", name)
+		}
+	}
+}
+
+// Reports actions html/template can't escape where they are, which
+// otherwise only fail when the email is sent.
+func (l *linter) checkEscaping() {
+	tmpl, err := l.template.parse(Email{}.funcs())
+	if err != nil {
+		l.report(noPos, false, "%s", err)
+		return
+	}
+
+	var escapeErr *htmltemplate.Error
+	if err := tmpl.Execute(io.Discard, TemplateContext{}); errors.As(err, &escapeErr) {
+		l.report(noPos, false, "%s", strings.TrimPrefix(escapeErr.Error(), "html/template:"))
+	}
+}
+
+type openTag struct {
+	name string
+	pos  parse.Pos
+}
+
+// Checks that every element of the body is closed, and its images and
+// links. Markdown is checked once it's made into HTML, whose lines
+// don't match the Markdown's.
+func (l *linter) checkHTML() {
+	source := l.template.Body
+	if l.template.usesLayout() {
+		var err error
+		if source, err = l.template.contentHTML(); err != nil {
+			l.report(noPos, false, "%s", err)
+			return
+		}
+	}
+
+	z := html.NewTokenizer(strings.NewReader(source))
+	stack := []openTag{}
+	offset := 0
+
+	for {
+		tt := z.Next()
+		pos := parse.Pos(offset)
+		if l.template.Markdown {
+			pos = noPos
+		}
+		offset += len(z.Raw())
+
+		switch tt {
+		case html.ErrorToken:
+			if !errors.Is(z.Err(), io.EOF) {
+				l.report(pos, false, "broken HTML: %s", z.Err())
+			}
+			for _, tag := range stack {
+				l.report(tag.pos, false, "<%s> is never closed", tag.name)
+			}
+			return
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			attrs := map[string]string{}
+			for hasAttr {
+				var key, value []byte
+				key, value, hasAttr = z.TagAttr()
+				attrs[string(key)] = string(value)
+			}
+
+			l.checkTag(string(name), attrs, pos)
+			if tt == html.StartTagToken && !slices.Contains(voidElements, string(name)) {
+				stack = append(stack, openTag{string(name), pos})
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			i := len(stack) - 1
+			for i >= 0 && stack[i].name != string(name) {
+				i--
+			}
+			if i < 0 {
+				l.report(pos, false, "</%s> closes an element that isn't open", name)
+				continue
+			}
+			for _, tag := range stack[i+1:] {
+				l.report(tag.pos, false, "<%s> is never closed", tag.name)
+			}
+			stack = stack[:i]
+		}
+	}
+}
+
+func (l *linter) checkTag(name string, attrs map[string]string, pos parse.Pos) {
+	switch name {
+	case "img":
+		if _, ok := attrs["alt"]; !ok {
+			l.report(pos, true, "image without alt text, which is shown when images are blocked")
+		}
+
+		src := attrs["src"]
+		switch {
+		case strings.HasPrefix(src, "cid:"):
+			cid := strings.TrimPrefix(src, "cid:")
+			if !slices.ContainsFunc(l.template.Images, func(i InlineImage) bool { return i.CID == cid }) {
+				l.report(pos, false, "image %s isn't one of the template's Images", src)
+			}
+		case strings.HasPrefix(src, "http://"), strings.HasPrefix(src, "https://"):
+			l.report(pos, true, "remote image %s is blocked by many mail clients, embed it instead", src)
+		}
+	case "a":
+		href := strings.TrimSpace(attrs["href"])
+		lower := strings.ToLower(href)
+		switch {
+		case href == "", strings.HasPrefix(href, "{{"), strings.HasPrefix(href, "#"):
+		case strings.HasPrefix(lower, "https://"), strings.HasPrefix(lower, "mailto:"), strings.HasPrefix(lower, "tel:"):
+		default:
+			l.report(pos, true, "link %s doesn't use https", href)
+		}
+	}
+}
+
+// Reports the template's images whose file can't be read, since no
+// email can be sent without them.
+func (l *linter) checkImages() {
+	for _, image := range l.template.Images {
+		if _, err := image.Read(); err != nil {
+			l.report(noPos, false, "image %s can't be read from %s", image.CID, image.Path)
+		}
+	}
+}
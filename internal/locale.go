@@ -0,0 +1,147 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The locale templates are written in, and recipients without a
+// language get unless DEFAULT_LOCALE says otherwise.
+const DefaultLocale = "en"
+
+// Names of the months and weekdays, and the layout's own text, in
+// every locale besides English.
+type locale struct {
+	months      [12]string
+	shortMonths [12]string
+	days        [7]string // From Sunday
+	shortDays   [7]string
+	text        map[string]string // Text of the layout, by its English
+}
+
+var locales = map[string]locale{
+	"fil": {
+		months:      [12]string{"Enero", "Pebrero", "Marso", "Abril", "Mayo", "Hunyo", "Hulyo", "Agosto", "Setyembre", "Oktubre", "Nobyembre", "Disyembre"},
+		shortMonths: [12]string{"Ene", "Peb", "Mar", "Abr", "May", "Hun", "Hul", "Ago", "Set", "Okt", "Nob", "Dis"},
+		days:        [7]string{"Linggo", "Lunes", "Martes", "Miyerkules", "Huwebes", "Biyernes", "Sabado"},
+		shortDays:   [7]string{"Lin", "Lun", "Mar", "Miy", "Huw", "Biy", "Sab"},
+		text: map[string]string{
+			"Visit us":                               "Bisitahin kami",
+			"View your password":                     "Tingnan ang iyong password",
+			"The link works once and expires on %s.": "Isang beses lang gagana ang link at mag-e-expire ito sa %s.",
+			"January 2, 2006 at 3:04 PM MST":         "January 2, 2006, 3:04 PM MST",
+		},
+	},
+	"zh": {
+		months:      [12]string{"一月", "二月", "三月", "四月", "五月", "六月", "七月", "八月", "九月", "十月", "十一月", "十二月"},
+		shortMonths: [12]string{"1月", "2月", "3月", "4月", "5月", "6月", "7月", "8月", "9月", "10月", "11月", "12月"},
+		days:        [7]string{"星期日", "星期一", "星期二", "星期三", "星期四", "星期五", "星期六"},
+		shortDays:   [7]string{"周日", "周一", "周二", "周三", "周四", "周五", "周六"},
+		text: map[string]string{
+			"Knowles IT Monitoring Team":             "Knowles IT 监控团队",
+			"Email":                                  "电子邮件",
+			"Visit us":                               "访问我们",
+			"View your password":                     "查看您的密码",
+			"The link works once and expires on %s.": "此链接仅可使用一次，将于 %s 失效。",
+			"January 2, 2006 at 3:04 PM MST":         "2006年1月2日 15:04 MST",
+		},
+	},
+}
+
+// Other ways the language column may name a locale.
+var localeAliases = map[string]string{
+	"english":  "en",
+	"filipino": "fil",
+	"tagalog":  "fil",
+	"tl":       "fil",
+	"chinese":  "zh",
+	"mandarin": "zh",
+	"中文":       "zh",
+}
+
+// Parses a language, e.g. fil, zh-CN or Filipino, into the locale of
+// the templates. An empty language is left empty.
+func ParseLocale(s string) (string, error) {
+	tag := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(s), "_", "-"))
+	if tag == "" {
+		return "", nil
+	}
+	if alias, ok := localeAliases[tag]; ok {
+		return alias, nil
+	}
+
+	// A region or script, e.g. zh-Hans-SG, doesn't change the templates
+	base, _, _ := strings.Cut(tag, "-")
+	if alias, ok := localeAliases[base]; ok {
+		base = alias
+	}
+	if _, ok := locales[base]; ok || base == DefaultLocale {
+		return base, nil
+	}
+
+	return "", fmt.Errorf("unknown language %q, use %s", s, strings.Join(Locales(), ", "))
+}
+
+// Returns every locale templates can be written in.
+func Locales() []string {
+	return []string{DefaultLocale, "fil", "zh"}
+}
+
+// Returns the layout's text in the locale.
+func translate(loc, text string) string {
+	if translated, ok := locales[loc].text[text]; ok {
+		return translated
+	}
+	return text
+}
+
+// Formats the time like time.Format, with the names of the months and
+// weekdays in the locale.
+func formatDate(t time.Time, layout, loc string) string {
+	names, ok := locales[loc]
+	if !ok {
+		return t.Format(layout)
+	}
+
+	// The names are formatted here and everything between them by
+	// time.Format, which reads them the same way: Jan and Mon only
+	// when no lowercase letter follows, as in Monday but not Month.
+	var sb strings.Builder
+	for layout != "" {
+		i, name, width := nextName(layout, t, names)
+		sb.WriteString(t.Format(layout[:i]))
+		if width == 0 {
+			break
+		}
+		sb.WriteString(name)
+		layout = layout[i+width:]
+	}
+	return sb.String()
+}
+
+// Finds the first month or weekday in the layout, and returns where it
+// is, its name in the locale and its width in the layout, which is 0
+// if there's none.
+func nextName(layout string, t time.Time, names locale) (int, string, int) {
+	for i := 0; i < len(layout); i++ {
+		rest := layout[i:]
+		switch {
+		case strings.HasPrefix(rest, "January"):
+			return i, names.months[t.Month()-1], len("January")
+		case strings.HasPrefix(rest, "Monday"):
+			return i, names.days[t.Weekday()], len("Monday")
+		case strings.HasPrefix(rest, "Jan") && !lowerAt(rest, 3):
+			return i, names.shortMonths[t.Month()-1], len("Jan")
+		case strings.HasPrefix(rest, "Mon") && !lowerAt(rest, 3):
+			return i, names.shortDays[t.Weekday()], len("Mon")
+		}
+	}
+	return len(layout), "", 0
+}
+
+func lowerAt(s string, i int) bool {
+	return i < len(s) && 'a' <= s[i] && s[i] <= 'z'
+}
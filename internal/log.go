@@ -0,0 +1,177 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Logs nothing until SetupLogging is called.
+var logger = slog.New(slog.DiscardHandler)
+
+// Starts writing JSON logs to the file set in LOG_FILE (email.log by
+// default) at the level set in LOG_LEVEL (info by default). The file is
+// rotated once it grows past LOG_MAX_SIZE_MB, keeping LOG_MAX_BACKUPS old
+// files. Logs never go to the terminal, so they don't disturb the TUI.
+// The returned function closes the log file.
+func SetupLogging() (func() error, error) {
+	// The .env file is optional here, LoadConfig reports it if it's missing
+	_ = godotenv.Load()
+
+	path := os.Getenv("LOG_FILE")
+	if path == "" {
+		path = "email.log"
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(getenv("LOG_LEVEL", "info"))); err != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVEL: %w", err)
+	}
+
+	maxSize, err := strconv.Atoi(getenv("LOG_MAX_SIZE_MB", "10"))
+	if err != nil || maxSize <= 0 {
+		return nil, fmt.Errorf("invalid LOG_MAX_SIZE_MB")
+	}
+
+	maxBackups, err := strconv.Atoi(getenv("LOG_MAX_BACKUPS", "5"))
+	if err != nil || maxBackups < 0 {
+		return nil, fmt.Errorf("invalid LOG_MAX_BACKUPS")
+	}
+
+	file, err := OpenRotatingFile(path, int64(maxSize)<<20, maxBackups)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	logger = slog.New(slog.NewJSONHandler(file, &slog.HandlerOptions{Level: level}))
+
+	return file.Close, nil
+}
+
+// A log file that is rotated to path.1, path.2, ... once it grows past
+// a maximum size.
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// Opens the file at path for appending, creating it if needed.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+func (r *RotatingFile) open() error {
+	if dir := filepath.Dir(r.path); dir != "." {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// Shifts path.N-1 to path.N, ..., path to path.1 and reopens path.
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+
+	if r.maxBackups == 0 {
+		os.Remove(r.path)
+	}
+	for i := r.maxBackups; i > 0; i-- {
+		src := r.path
+		if i > 1 {
+			src = fmt.Sprintf("%s.%d", r.path, i-1)
+		}
+		if err := os.Rename(src, fmt.Sprintf("%s.%d", r.path, i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return r.open()
+}
+
+// Logs a delivery attempt.
+func logAttempt(transport Transport, raw RawMessage, response string, start time.Time, err error) {
+	if signing, ok := transport.(SigningTransport); ok {
+		transport = signing.Transport
+	}
+
+	attrs := []any{
+		slog.String("transport", fmt.Sprintf("%T", transport)),
+		slog.String("template", raw.Template),
+		slog.String("recipients", strings.Join(raw.Rcpts, ", ")),
+		slog.String("message_id", raw.MessageID),
+		slog.String("response", response),
+		slog.Duration("duration", time.Since(start)),
+	}
+
+	if err != nil {
+		if rejected := Rejections(err); len(rejected) > 0 {
+			attrs = append(attrs, slog.String("rejected", joinRejections(rejected)))
+		}
+		logger.Error("send failed", append(attrs, slog.String("error", err.Error()))...)
+		return
+	}
+
+	logger.Info("send", attrs...)
+}
+
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,74 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// Sends messages through the Mailgun API, which takes the rendered
+// message as it is.
+type MailgunTransport struct {
+	API APIConfig
+}
+
+func (t MailgunTransport) Send(ctx context.Context, raw RawMessage) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+
+	// The recipients given here are the envelope, which includes BCCs
+	for _, rcpt := range raw.Rcpts {
+		if err := form.WriteField("to", rcpt); err != nil {
+			return "", err
+		}
+	}
+
+	file, err := form.CreateFormFile("message", "message.eml")
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Write(raw.Data); err != nil {
+		return "", err
+	}
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	url := fmt.Sprintf("%s/v3/%s/messages.mime", t.API.BaseURL, t.API.Domain)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.SetBasicAuth("api", t.API.Key)
+
+	status, _, data, err := doRequest(req)
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		response.Message = strings.TrimSpace(string(data))
+	}
+
+	if status >= 300 {
+		// Mailgun only names invalid recipients in its message
+		kind, rejected := statusKind(status), []string{}
+		if kind == ErrRejected {
+			rejected = recipientsIn(response.Message, raw.Rcpts)
+		}
+		return "", newSendError(TransportMailgun, status, "", response.Message, kind, rejected)
+	}
+
+	return fmt.Sprintf("%d %s, message id %s", status, response.Message, response.ID), nil
+}
@@ -3,13 +3,19 @@
 package model
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"math/rand"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/progress"
 	"github.com/charmbracelet/bubbles/table"
 	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	email "github.com/duanechan/monitoring-utils/email/internal"
@@ -29,10 +35,23 @@ type (
 		mode             mode
 		parseResult      email.ParseResult
 		config           email.EmailConfig
+		transport        email.Transport
 		input            textinput.Model
 		table            table.Model
 		progressBar      progress.Model
-		progressChan     chan float64
+		preview          viewport.Model
+		previewEmail     email.Email
+		varInput         textinput.Model
+		testTo           email.User
+		testStatus       string
+		cancel           context.CancelFunc
+		cancelling       bool
+		cancelled        bool
+		sendCtx          context.Context
+		job              *email.Job
+		sendStart        time.Time
+		sendElapsed      time.Duration
+		sentCount        int
 	}
 
 	templates struct {
@@ -41,28 +60,44 @@ type (
 	}
 
 	mode struct {
-		Quit   bool
-		Help   bool
-		Parser bool
-		Editor bool
-		Send   bool
+		Quit    bool
+		Help    bool
+		Parser  bool
+		Editor  bool
+		Send    bool
+		Preview bool
+		Vars    bool
 	}
 
 	sendEmails       struct{}
 	readInputMessage struct{}
 	initializeEditor struct{}
-	progressMsg      struct {
-		progress float64
-		results  []string
+	batchQueuedMsg   struct {
+		job *email.Job
+		err error
+	}
+	recipientSentMsg struct {
+		index   int
+		message email.QueuedMessage
+		sent    bool // False if the rest of the batch was cancelled elsewhere
+		err     error
+	}
+	sendTickMsg time.Time
+	testSentMsg struct {
+		to  email.User
+		err error
 	}
 )
 
-func InitializeModel(rName, rEmail string, config email.EmailConfig) EmailModel {
+func InitializeModel(rName, rEmail string, testTo email.User, config email.EmailConfig, transport email.Transport) EmailModel {
+	if testTo.Email == "" {
+		testTo = config.From
+	}
+
 	return EmailModel{
-		input:        initParser(),
-		progressChan: make(chan float64),
-		name:         rName,
-		email:        rEmail,
+		input: initParser(),
+		name:  rName,
+		email: rEmail,
 		goodbyes: []string{
 			lipgloss.NewStyle().Bold(true).Foreground(Primary).Render("\nGoodbye! See you next time. 👋\n\n"),
 			lipgloss.NewStyle().Bold(true).Foreground(Primary).Render("\nExiting... Have a great day!\n\n"),
@@ -78,11 +113,11 @@ func InitializeModel(rName, rEmail string, config email.EmailConfig) EmailModel
 			lipgloss.NewStyle().Bold(true).Foreground(Primary).Render("\nProgram terminated. Catch you later!\n\n"),
 			lipgloss.NewStyle().Bold(true).Foreground(Primary).Render("\nSigning out... Keep being awesome!\n\n"),
 		},
-		templates: []templates{
+		templates: enabledTemplates(config, []templates{
 			{name: "CRED", template: email.Credentials},
 			{name: "LATE", template: email.Late},
 			{name: "ABST", template: email.Credentials},
-		},
+		}),
 		mode: mode{
 			Quit:   false,
 			Help:   false,
@@ -90,10 +125,23 @@ func InitializeModel(rName, rEmail string, config email.EmailConfig) EmailModel
 			Editor: rName != "" && rEmail != "",
 			Send:   false,
 		},
-		config: config,
+		config:    config,
+		transport: transport,
+		testTo:    testTo,
 	}
 }
 
+// Keeps the templates the config's profile can send.
+func enabledTemplates(config email.EmailConfig, all []templates) []templates {
+	enabled := []templates{}
+	for _, t := range all {
+		if config.HasTemplate(t.name) {
+			enabled = append(enabled, t)
+		}
+	}
+	return enabled
+}
+
 func (e EmailModel) Init() tea.Cmd {
 	if e.name != "" && e.email != "" {
 		return tea.Batch(textinput.Blink, e.handleInput)
@@ -108,6 +156,13 @@ func (e EmailModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if e.mode.Preview {
+			return e.updatePreview(msg)
+		}
+		if e.mode.Vars {
+			return e.updateVars(msg)
+		}
+
 		switch msg.String() {
 		case "?":
 			e.mode.Help = !e.mode.Help
@@ -123,6 +178,11 @@ func (e EmailModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return e, textinput.Blink
 			}
 			if e.mode.Send && e.progressBar.Percent() < 1.0 {
+				// Stop after the email that is being sent
+				if e.cancel != nil && !e.cancelling {
+					e.cancel()
+					e.cancelling = true
+				}
 				return e, nil
 			}
 			e.mode.Quit = !e.mode.Quit
@@ -149,6 +209,24 @@ func (e EmailModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				e.input.Focus()
 				return e, textinput.Blink
 			}
+		case "t":
+			if e.mode.Editor && !e.mode.Send && !e.mode.Quit {
+				if len(e.parseResult.Recipients) == 0 {
+					e.err = fmt.Errorf("no valid recipient to build the test email from")
+					return e, nil
+				}
+				e.err = nil
+				e.testStatus = fmt.Sprintf("Sending test email to %s...", e.testTo.Email)
+				return e, e.sendTest
+			}
+		case "v":
+			if e.mode.Editor && !e.mode.Send && !e.mode.Quit {
+				return e.openVars()
+			}
+		case "p":
+			if e.mode.Editor && !e.mode.Send && !e.mode.Quit && len(e.parseResult.Raw) > 0 {
+				return e.openPreview(), nil
+			}
 		case "shift+tab":
 			if (!e.mode.Quit || !e.mode.Editor || !e.mode.Send) && e.selectedTemplate > 0 {
 				e.selectedTemplate--
@@ -201,11 +279,65 @@ func (e EmailModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case sendEmails:
 		e.mode.Send = true
+		e.testStatus = ""
 		return e, e.SendEmails()
 
-	case progressMsg:
-		e.sendResults = msg.results
-		cmds = append(cmds, e.progressBar.SetPercent(float64(msg.progress)))
+	case batchQueuedMsg:
+		if msg.err != nil {
+			e.sendResults = append(
+				e.sendResults, lipgloss.NewStyle().
+					Foreground(lipgloss.Color(Red)).
+					Render(fmt.Sprintf("✖ Failed to queue the emails: %s\n", msg.err)))
+			return e, e.finishSending()
+		}
+		e.job = msg.job
+		return e, e.sendNext()
+
+	case recipientSentMsg:
+		if errors.Is(msg.err, context.Canceled) || (!msg.sent && msg.err == nil) {
+			return e, e.cancelSending()
+		}
+
+		if msg.sent {
+			e.sentCount++
+			e.setStatus(msg.index, msg.message)
+			e.sendResults = append(e.sendResults, resultLine(msg.message))
+		}
+
+		if msg.err != nil {
+			e.sendResults = append(
+				e.sendResults, lipgloss.NewStyle().
+					Foreground(lipgloss.Color(Red)).
+					Render(fmt.Sprintf("✖ Stopped, the queue could not be updated: %s\nRun \"resume %s\" to finish the batch.\n", msg.err, e.job.ID)))
+			return e, e.finishSending()
+		}
+
+		if e.job.Next() < 0 {
+			return e, e.finishSending()
+		}
+
+		return e, tea.Batch(
+			e.progressBar.SetPercent(float64(e.sentCount)/float64(len(e.job.Messages))),
+			e.sendNext(),
+		)
+
+	case sendTickMsg:
+		if e.mode.Send && e.progressBar.Percent() < 1.0 {
+			return e, sendTick()
+		}
+		return e, nil
+
+	case testSentMsg:
+		if msg.err != nil {
+			e.testStatus = ""
+			e.err = fmt.Errorf("test email to %s failed: %w", msg.to.Email, msg.err)
+			return e, nil
+		}
+		e.testStatus = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("46")).
+			Render(fmt.Sprintf("✔ Test email sent to %s", msg.to.Email))
+		return e, nil
+
 	}
 
 	e.input, cmd = e.input.Update(msg)
@@ -228,6 +360,10 @@ func (e EmailModel) View() string {
 		return e.quitView()
 	}
 
+	if e.mode.Preview {
+		return e.previewView()
+	}
+
 	sections := []string{}
 
 	if e.mode.Parser {
@@ -251,20 +387,38 @@ func (e EmailModel) View() string {
 		sections = append(sections, e.table.View())
 		if e.mode.Send {
 			if !e.progressBar.IsAnimating() && e.progressBar.Percent() == 1.0 {
-				sections = append(
-					sections, lipgloss.NewStyle().
-						Bold(true).
-						Foreground(lipgloss.Color("46")).
-						Render("\nDone!\n"))
+				if e.cancelled {
+					sections = append(
+						sections, lipgloss.NewStyle().
+							Bold(true).
+							Foreground(lipgloss.Color("#FFFF00")).
+							Render("\nCancelled!\n"))
+				} else {
+					sections = append(
+						sections, lipgloss.NewStyle().
+							Bold(true).
+							Foreground(lipgloss.Color("46")).
+							Render("\nDone!\n"))
+				}
 				if len(e.sendResults) > 0 {
 					sections = append(sections, e.sendResults...)
 				}
+				sections = append(
+					sections, lipgloss.NewStyle().
+						Foreground(Gray).
+						Render(fmt.Sprintf("Finished in %s\n", formatDuration(e.sendElapsed))))
 				sections = append(sections, "Press ESC to go back")
 			} else {
-				sections = append(sections, "\nSending emails...\n")
+				if e.cancelling {
+					sections = append(sections, "\nCancelling after the current email...\n")
+				} else {
+					sections = append(sections, "\nSending emails... (esc to cancel)\n")
+				}
 				sections = append(sections, e.progressBar.View())
+				sections = append(sections, e.etaView())
 			}
 		} else {
+			sections = append(sections, e.varsView())
 			sections = append(sections, e.resultView())
 		}
 	}
@@ -280,61 +434,264 @@ func (e EmailModel) View() string {
 	return lipgloss.JoinVertical(lipgloss.Left, sections...)
 }
 
+// Opens the preview pane for the highlighted recipient.
+func (e EmailModel) openPreview() EmailModel {
+	e.previewEmail = email.Email{
+		Body:   e.templates[e.selectedTemplate].template,
+		To:     e.parseResult.Recipient(e.table.Cursor()),
+		Config: e.config,
+	}
+
+	text, err := e.previewEmail.Text()
+	if err != nil {
+		e.err = err
+		return e
+	}
+
+	e.preview = viewport.New(100, 20)
+	e.preview.SetContent(lipgloss.NewStyle().Width(96).Render(text))
+	e.mode.Preview = true
+	return e
+}
+
+// Opens the prompt that sets a variable of the batch.
+func (e EmailModel) openVars() (EmailModel, tea.Cmd) {
+	e.err = nil
+	e.varInput = initVarInput()
+	e.mode.Vars = true
+	return e, textinput.Blink
+}
+
+func (e EmailModel) updateVars(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		e.mode.Quit = true
+		e.goodbyeMsg = e.goodbyes[rand.Intn(len(e.goodbyes))]
+		return e, tea.Quit
+	case "esc":
+		e.err = nil
+		e.mode.Vars = false
+		return e, nil
+	case "enter":
+		name, value, err := email.ParseVar(e.varInput.Value())
+		if err != nil {
+			e.err = err
+			return e, nil
+		}
+
+		// The map is shared with the config the app started with
+		vars := maps.Clone(e.config.Vars)
+		if vars == nil {
+			vars = map[string]string{}
+		}
+		if value == "" {
+			delete(vars, name)
+		} else {
+			vars[name] = value
+		}
+		e.config.Vars = vars
+
+		e.err = nil
+		e.mode.Vars = false
+		return e, nil
+	}
+
+	var cmd tea.Cmd
+	e.varInput, cmd = e.varInput.Update(msg)
+	return e, cmd
+}
+
+func (e EmailModel) updatePreview(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		e.mode.Quit = true
+		e.goodbyeMsg = e.goodbyes[rand.Intn(len(e.goodbyes))]
+		return e, tea.Quit
+	case "esc", "p":
+		e.err = nil
+		e.mode.Preview = false
+		return e, nil
+	case "?":
+		e.mode.Help = !e.mode.Help
+		return e, nil
+	case "o":
+		e.err = nil
+		path, err := e.previewEmail.WritePreview()
+		if err != nil {
+			e.err = err
+			return e, nil
+		}
+		if err := email.OpenInBrowser(path); err != nil {
+			e.err = err
+		}
+		return e, nil
+	}
+
+	var cmd tea.Cmd
+	e.preview, cmd = e.preview.Update(msg)
+	return e, cmd
+}
+
 func (e EmailModel) startSending() tea.Msg { return sendEmails{} }
 
+// Sends the email for the first valid recipient to the test address.
+func (e EmailModel) sendTest() tea.Msg {
+	em := email.Email{
+		Body:   e.templates[e.selectedTemplate].template,
+		To:     e.parseResult.Recipients[0],
+		Config: e.config,
+	}
+
+	return testSentMsg{to: e.testTo, err: em.SendTest(e.transport, e.testTo)}
+}
+
 func (e EmailModel) handleInput() tea.Msg { return readInputMessage{} }
 
 func (e EmailModel) initializeEditor() tea.Msg { return initializeEditor{} }
 
+// Starts sending the batch. The batch is written to the queue first, then
+// each recipient is sent by its own command, so the table and progress bar
+// update as every email goes out.
 func (e *EmailModel) SendEmails() tea.Cmd {
 	e.progressBar = progress.New(progress.WithGradient("#005DAD", "#6796BF"))
-	total := float64(len(e.parseResult.Recipients))
-	progress := 0.0
+	e.sendResults = nil
+	e.sentCount = 0
+	e.sendStart = time.Now()
+	e.sendElapsed = 0
+	e.cancelled = false
+	e.cancelling = false
+	e.sendCtx, e.cancel = context.WithCancel(context.Background())
 	e.input.Focus()
 
-	return func() tea.Msg {
-		results := []string{}
-		progressChan := make(chan float64)
-		resultChan := make(chan string)
+	name := e.templates[e.selectedTemplate].name
+	template := e.templates[e.selectedTemplate].template
+	recipients := e.parseResult.Recipients
+	config := e.config
 
-		go func() {
-			for p := range progressChan {
-				e.progressBar.SetPercent(p)
-			}
-		}()
+	queue := func() tea.Msg {
+		// Write the batch to the queue before sending anything
+		job, err := email.QueueBatch(name, template, recipients, config, time.Now(), false)
+		return batchQueuedMsg{job: &job, err: err}
+	}
 
-		for _, r := range e.parseResult.Recipients {
+	return tea.Batch(queue, sendTick())
+}
 
-			em := email.Email{
-				Body:   e.templates[e.selectedTemplate].template,
-				To:     email.User{Name: r.Name, Email: r.Email},
-				Config: e.config,
-			}
+// Marks the next recipient as sending and returns the command that sends it.
+func (e *EmailModel) sendNext() tea.Cmd {
+	i := e.job.Next()
+	if i < 0 {
+		return e.finishSending()
+	}
 
-			if err := em.Send(); err != nil {
-				results = append(
-					results,
-					lipgloss.NewStyle().
-						Foreground(lipgloss.Color(Red)).
-						Render(fmt.Sprintf("✖ Failed: %s\n", r.Email)))
-			} else {
-				results = append(
-					results, lipgloss.NewStyle().
-						Foreground(lipgloss.Color("46")).
-						Render(fmt.Sprintf("✔ Sent: %s\n", r.Email)))
-			}
+	e.setStatus(i, email.QueuedMessage{State: email.Sending})
 
-			progress++
-			progressChan <- progress / total
-		}
+	job, ctx, transport := e.job, e.sendCtx, e.transport
+	return func() tea.Msg {
+		m, ok, err := job.SendNext(ctx, transport)
+		return recipientSentMsg{index: i, message: m, sent: ok, err: err}
+	}
+}
 
-		close(progressChan)
-		close(resultChan)
-		return progressMsg{
-			progress: 1.0,
-			results:  results,
+// Marks the rest of the batch as not sent and adds a partial report.
+func (e *EmailModel) cancelSending() tea.Cmd {
+	_, err := e.job.CancelPending()
+	for i, m := range e.job.Messages {
+		if m.State == email.Cancelled {
+			e.setStatus(i, m)
+			e.sendResults = append(e.sendResults, resultLine(m))
 		}
 	}
+
+	sent, failed := e.job.Count()
+	e.sendResults = append(
+		e.sendResults,
+		fmt.Sprintf("%d sent, %d failed, %d not sent.\n", sent, failed, len(e.job.Messages)-sent-failed))
+
+	if err != nil {
+		e.sendResults = append(
+			e.sendResults, lipgloss.NewStyle().
+				Foreground(lipgloss.Color(Red)).
+				Render(fmt.Sprintf("✖ The queue could not be updated: %s\n", err)))
+	}
+
+	e.cancelled = true
+	return e.finishSending()
+}
+
+func (e *EmailModel) finishSending() tea.Cmd {
+	e.cancelling = false
+	e.sendElapsed = time.Since(e.sendStart)
+	if e.cancel != nil {
+		e.cancel()
+		e.cancel = nil
+	}
+	if e.job != nil {
+		e.job.Unlock()
+	}
+	return e.progressBar.SetPercent(1.0)
+}
+
+// Shows the state of the i-th recipient of the batch in the table.
+func (e *EmailModel) setStatus(i int, m email.QueuedMessage) {
+	rows := e.table.Rows()
+	row := e.parseResult.Rows[i]
+
+	var status string
+	switch m.State {
+	case email.Sending:
+		status = lipgloss.NewStyle().Foreground(Primary).Render("⋯ Sending...")
+	case email.Sent:
+		status = lipgloss.NewStyle().Foreground(lipgloss.Color("46")).Render("✔ Sent")
+	case email.Failed:
+		status = lipgloss.NewStyle().Foreground(lipgloss.Color("196")).Render("✖ Failed: " + m.Reason())
+	case email.Cancelled:
+		status = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFF00")).Render("━ Not sent")
+	}
+
+	rows[row][3] = status
+	e.table.SetRows(rows)
+}
+
+func resultLine(m email.QueuedMessage) string {
+	switch m.State {
+	case email.Sent:
+		return lipgloss.NewStyle().
+			Foreground(lipgloss.Color("46")).
+			Render(fmt.Sprintf("✔ Sent: %s\n", m.To.Email))
+	case email.Cancelled:
+		return lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#FFFF00")).
+			Render(fmt.Sprintf("━ Not sent: %s\n", m.To.Email))
+	default:
+		return lipgloss.NewStyle().
+			Foreground(lipgloss.Color(Red)).
+			Render(fmt.Sprintf("✖ Failed: %s\n", m.To.Email))
+	}
+}
+
+func sendTick() tea.Cmd {
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg { return sendTickMsg(t) })
+}
+
+func (e EmailModel) etaView() string {
+	total := len(e.parseResult.Recipients)
+	elapsed := time.Since(e.sendStart)
+
+	eta := "--"
+	if e.sentCount > 0 {
+		remaining := elapsed / time.Duration(e.sentCount) * time.Duration(total-e.sentCount)
+		eta = formatDuration(remaining)
+	}
+
+	return lipgloss.NewStyle().
+		Foreground(Gray).
+		Render(fmt.Sprintf("%d/%d · elapsed %s · ETA %s", e.sentCount, total, formatDuration(elapsed), eta))
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
 }
 
 func (e EmailModel) headerView() string {
@@ -386,11 +743,74 @@ func (e EmailModel) resultView() string {
 					lipgloss.Center,
 					"Do you want to send the emails?",
 					lipgloss.JoinHorizontal(lipgloss.Center, cancel, confirm),
+					lipgloss.NewStyle().
+						Foreground(Gray).
+						Render(fmt.Sprintf("t / send a test email to %s", e.testTo.Email)),
 				),
 			),
+		e.testStatus,
 	)
 }
 
+func (e EmailModel) previewView() string {
+	labelStyle := lipgloss.NewStyle().Bold(true).Foreground(Primary).Width(10)
+
+	cc := "-"
+	if list := slices.Concat(e.previewEmail.Config.CC, e.previewEmail.To.CC); len(list) > 0 {
+		cc = email.JoinUsers(list)
+	}
+
+	bcc := "-"
+	if list := slices.Concat(e.previewEmail.Config.BCC, e.previewEmail.To.BCC); len(list) > 0 {
+		bcc = email.JoinUsers(list)
+	}
+
+	attachments := "-"
+	if files := slices.Concat(e.previewEmail.Body.Attachments, e.previewEmail.To.Attachments); len(files) > 0 {
+		attachments = strings.Join(files, ", ")
+	}
+
+	subject, err := e.previewEmail.Subject()
+	if err != nil {
+		subject = err.Error()
+	}
+
+	headers := lipgloss.JoinVertical(
+		lipgloss.Left,
+		labelStyle.Render("Subject:")+subject,
+		labelStyle.Render("From:")+e.previewEmail.Config.From.String(),
+		labelStyle.Render("To:")+e.previewEmail.To.String(),
+		labelStyle.Render("CC:")+cc,
+		labelStyle.Render("BCC:")+bcc,
+		labelStyle.Render("Attach:")+attachments,
+	)
+
+	sections := []string{
+		"\n",
+		lipgloss.NewStyle().
+			Padding(1, 1).
+			Background(lipgloss.Color("42")).
+			Render("Preview: " + e.templates[e.selectedTemplate].name),
+		"\n",
+		headers,
+		"\n",
+		EditorStyle.Render(e.preview.View()),
+		lipgloss.NewStyle().Foreground(Gray).Render(fmt.Sprintf("%3.f%%", e.preview.ScrollPercent()*100)),
+	}
+
+	if e.err != nil {
+		sections = append(sections, "\n"+e.errorView())
+	}
+
+	sections = append(
+		sections, lipgloss.NewStyle().
+			Foreground(Gray).
+			Padding(1, 2).
+			Render("↑/↓ / scroll    o / open in browser    esc / back"))
+
+	return lipgloss.JoinVertical(lipgloss.Left, sections...)
+}
+
 func (e EmailModel) helpView() string {
 	if !e.mode.Help {
 		return lipgloss.NewStyle().
@@ -412,6 +832,12 @@ func (e EmailModel) helpView() string {
 			lipgloss.NewStyle().Padding(1, 2).Render("tab / next template"),
 			lipgloss.NewStyle().Padding(1, 2).Render("shift+tab / previous template"),
 		),
+		lipgloss.JoinHorizontal(
+			lipgloss.Center,
+			lipgloss.NewStyle().Padding(1, 2).Render("↑/↓ / select recipient"),
+			lipgloss.NewStyle().Padding(1, 2).Render("p / preview email"),
+			lipgloss.NewStyle().Padding(1, 2).Render("v / set a variable"),
+		),
 	)
 
 	return lipgloss.NewStyle().
@@ -464,13 +890,45 @@ func initParser() textinput.Model {
 	return ti
 }
 
+func initVarInput() textinput.Model {
+	ti := textinput.New()
+	ti.Prompt = lipgloss.NewStyle().Foreground(Primary).Render("Set a variable: ")
+	ti.Placeholder = "startDate=2026-11-03, or startDate= to remove it"
+	ti.Width = 90
+	ti.CharLimit = 200
+	ti.Focus()
+
+	return ti
+}
+
+// Lists the variables of the batch, or shows the prompt that sets one.
+func (e EmailModel) varsView() string {
+	if e.mode.Vars {
+		return lipgloss.NewStyle().Padding(1, 0).Render(e.varInput.View())
+	}
+
+	if len(e.config.Vars) == 0 {
+		return ""
+	}
+
+	vars := []string{}
+	for _, name := range slices.Sorted(maps.Keys(e.config.Vars)) {
+		vars = append(vars, name+"="+e.config.Vars[name])
+	}
+
+	return lipgloss.NewStyle().
+		Foreground(Gray).
+		Padding(1, 0, 0).
+		Render("Variables: " + strings.Join(vars, ", "))
+}
+
 func initEditor(result email.ParseResult) table.Model {
 	rows := []table.Row{}
 
 	redStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
 	greenStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("46"))
 
-	for i, r := range result.Raw {
+	for i := range result.Raw {
 		var status string
 		if e, exists := result.BadEmails[i+1]; exists {
 			if strings.Contains(e, "Duplicate") {
@@ -482,20 +940,26 @@ func initEditor(result email.ParseResult) table.Model {
 			status = greenStyle.Render("✔")
 		}
 
-		rows = append(rows, table.Row{fmt.Sprintf("%d", i+1), r[0], r[1], status})
+		rows = append(rows, table.Row{
+			fmt.Sprintf("%d", result.Row(i)),
+			result.Field(i, email.ColumnName),
+			result.Field(i, email.ColumnEmail),
+			status,
+		})
 	}
 
 	cols := []table.Column{
 		{Title: "Row", Width: 5},
 		{Title: "Name", Width: 25},
 		{Title: "Email", Width: 25},
-		{Title: "Valid", Width: 100},
+		{Title: "Status", Width: 100},
 	}
 
 	t := table.New(
 		table.WithHeight(len(result.Raw)+1),
 		table.WithColumns(cols),
 		table.WithRows(rows),
+		table.WithFocused(true),
 		table.WithStyles(table.Styles{
 			Header:   lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("210")),
 			Selected: lipgloss.NewStyle().Background(lipgloss.Color("236")).Foreground(lipgloss.Color("15")),
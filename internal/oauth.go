@@ -0,0 +1,284 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+// SMTP authentication methods, set with SMTP_AUTH.
+const (
+	AuthPlain   = "plain"   // App password in SMTP_PASS
+	AuthXOAUTH2 = "xoauth2" // OAuth2 access token
+)
+
+type OAuthConfig struct {
+	Provider     string // google or microsoft
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	DeviceURL    string
+	Scopes       []string
+	TokenFile    string // Where the refresh token is stored
+}
+
+// Default SMTP server, endpoints and scopes of each OAuth2 provider.
+var providers = map[string]struct {
+	host     string
+	endpoint oauth2.Endpoint
+	scopes   []string
+}{
+	"google": {
+		host:     "smtp.gmail.com",
+		endpoint: endpoints.Google,
+		scopes:   []string{"https://mail.google.com/"},
+	},
+	"microsoft": {
+		host:     "smtp.office365.com",
+		endpoint: endpoints.AzureAD("common"),
+		scopes:   []string{"https://outlook.office.com/SMTP.Send", "offline_access"},
+	},
+}
+
+// Reads the OAUTH_* environment variables, and the client secret from
+// the secret store. The endpoints default to those of OAUTH_PROVIDER
+// and can be overridden, e.g. to test against a local server.
+func loadOAuthConfig(secrets SecretStore) (OAuthConfig, error) {
+	name := strings.ToLower(getenv("OAUTH_PROVIDER", "google"))
+	provider, ok := providers[name]
+	if !ok {
+		return OAuthConfig{}, fmt.Errorf("unknown OAUTH_PROVIDER %q", name)
+	}
+
+	clientID := os.Getenv("OAUTH_CLIENT_ID")
+	if clientID == "" {
+		return OAuthConfig{}, fmt.Errorf("OAUTH_CLIENT_ID environment variable not set")
+	}
+
+	clientSecret, err := secrets.Get("OAUTH_CLIENT_SECRET")
+	if err != nil {
+		return OAuthConfig{}, err
+	}
+
+	scopes := provider.scopes
+	if list := os.Getenv("OAUTH_SCOPES"); list != "" {
+		scopes = strings.Fields(strings.ReplaceAll(list, ",", " "))
+	}
+
+	return OAuthConfig{
+		Provider:     name,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      getenv("OAUTH_AUTH_URL", provider.endpoint.AuthURL),
+		TokenURL:     getenv("OAUTH_TOKEN_URL", provider.endpoint.TokenURL),
+		DeviceURL:    getenv("OAUTH_DEVICE_URL", provider.endpoint.DeviceAuthURL),
+		Scopes:       scopes,
+		TokenFile:    getenv("OAUTH_TOKEN_FILE", "oauth-token.json"),
+	}, nil
+}
+
+func (c OAuthConfig) client() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:       c.AuthURL,
+			TokenURL:      c.TokenURL,
+			DeviceAuthURL: c.DeviceURL,
+		},
+		Scopes: c.Scopes,
+	}
+}
+
+// Guards the token file, which is rewritten whenever a token is refreshed.
+var tokenMu sync.Mutex
+
+// Returns a valid access token, refreshing it with the stored refresh
+// token if it has expired.
+func (c OAuthConfig) AccessToken(ctx context.Context) (string, error) {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+
+	token, err := c.readToken()
+	if err != nil {
+		return "", err
+	}
+
+	fresh, err := c.client().TokenSource(ctx, token).Token()
+	if err != nil {
+		return "", fmt.Errorf("failed to refresh OAuth2 token: %w", err)
+	}
+
+	if fresh.AccessToken != token.AccessToken {
+		logger.Info("oauth2 token refreshed", "expiry", fresh.Expiry)
+		if err := c.writeToken(fresh); err != nil {
+			return "", err
+		}
+	}
+
+	return fresh.AccessToken, nil
+}
+
+// Authorizes the account with the device-code flow: prompt is given a
+// URL and a code for the user to enter there, on any device.
+func (c OAuthConfig) AuthorizeDevice(ctx context.Context, prompt func(url, code string)) error {
+	config := c.client()
+
+	auth, err := config.DeviceAuth(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start device authorization: %w", err)
+	}
+
+	url := auth.VerificationURIComplete
+	if url == "" {
+		url = auth.VerificationURI
+	}
+	prompt(url, auth.UserCode)
+
+	token, err := config.DeviceAccessToken(ctx, auth)
+	if err != nil {
+		return fmt.Errorf("device authorization failed: %w", err)
+	}
+
+	return c.saveAuthorized(token)
+}
+
+// Authorizes the account in the browser, with a server on the loopback
+// address receiving the redirect: open is given the URL to visit.
+func (c OAuthConfig) AuthorizeLoopback(ctx context.Context, open func(url string)) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	config := c.client()
+	config.RedirectURL = fmt.Sprintf("http://%s/", listener.Addr())
+
+	state, err := randomString()
+	if err != nil {
+		return err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	// Only the first redirect counts, so that loading the page again
+	// doesn't block the handler
+	codes := make(chan string, 1)
+	errs := make(chan error, 1)
+	var once sync.Once
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case query.Get("state") != state:
+			http.Error(w, "Invalid state.", http.StatusBadRequest)
+			return
+		case query.Get("error") != "":
+			fmt.Fprintln(w, "Authorization failed. You can close this window.")
+			once.Do(func() { errs <- fmt.Errorf("authorization failed: %s", query.Get("error")) })
+		default:
+			fmt.Fprintln(w, "Authorized. You can close this window.")
+			once.Do(func() { codes <- query.Get("code") })
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	open(config.AuthCodeURL(state,
+		oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("prompt", "consent"),
+		oauth2.S256ChallengeOption(verifier)))
+
+	var code string
+	select {
+	case code = <-codes:
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+
+	return c.saveAuthorized(token)
+}
+
+func (c OAuthConfig) saveAuthorized(token *oauth2.Token) error {
+	if token.RefreshToken == "" {
+		return fmt.Errorf("no refresh token was returned, check the offline access scope")
+	}
+
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+
+	if err := c.writeToken(token); err != nil {
+		return err
+	}
+
+	logger.Info("oauth2 authorized", "provider", c.Provider, "token_file", c.TokenFile)
+	return nil
+}
+
+func (c OAuthConfig) readToken() (*oauth2.Token, error) {
+	data, err := os.ReadFile(c.TokenFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no OAuth2 token at %s, run the auth command first", c.TokenFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var token oauth2.Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("invalid OAuth2 token file: %w", err)
+	}
+
+	return &token, nil
+}
+
+// Writes the token file atomically, readable by the owner only.
+func (c OAuthConfig) writeToken(token *oauth2.Token) error {
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(c.TokenFile); dir != "." {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+	}
+
+	tmp := c.TokenFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, c.TokenFile)
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,259 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Starts a stand-in token endpoint that records the forms it's posted,
+// with the client's credentials, and grants the token.
+func tokenEndpoint(t *testing.T, grant map[string]any) (*httptest.Server, *[]url.Values) {
+	t.Helper()
+
+	forms := []url.Values{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		form := r.PostForm
+		if id, secret, ok := r.BasicAuth(); ok {
+			form.Set("client_id", id)
+			form.Set("client_secret", secret)
+		}
+		forms = append(forms, form)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(grant)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &forms
+}
+
+// Loads the OAuth2 settings from the environment, with the token
+// endpoint at the stand-in's URL.
+func testOAuthConfig(t *testing.T, tokenURL string) OAuthConfig {
+	t.Helper()
+
+	t.Setenv("OAUTH_PROVIDER", "google")
+	t.Setenv("OAUTH_CLIENT_ID", "client-id")
+	t.Setenv("OAUTH_CLIENT_SECRET", "client-secret")
+	t.Setenv("OAUTH_AUTH_URL", tokenURL+"/auth")
+	t.Setenv("OAUTH_TOKEN_URL", tokenURL+"/token")
+	t.Setenv("OAUTH_TOKEN_FILE", filepath.Join(t.TempDir(), "oauth", "token.json"))
+
+	config, err := loadOAuthConfig(envStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func readTestToken(t *testing.T, path string) oauth2.Token {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var token oauth2.Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// Checks that the form has the fields, whichever way the client sent
+// its credentials.
+func checkForm(t *testing.T, form url.Values, fields map[string]string) {
+	t.Helper()
+	for key, want := range fields {
+		if got := form.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestAccessTokenRefreshes(t *testing.T) {
+	server, forms := tokenEndpoint(t, map[string]any{
+		"access_token":  "new-access",
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+		"refresh_token": "new-refresh",
+	})
+	config := testOAuthConfig(t, server.URL)
+
+	expired := &oauth2.Token{AccessToken: "old-access", TokenType: "Bearer", RefreshToken: "old-refresh", Expiry: time.Now().Add(-time.Minute)}
+	if err := config.writeToken(expired); err != nil {
+		t.Fatal(err)
+	}
+
+	access, err := config.AccessToken(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access != "new-access" {
+		t.Errorf("access token = %q, want new-access", access)
+	}
+
+	if len(*forms) != 1 {
+		t.Fatalf("token endpoint was called %d times, want once", len(*forms))
+	}
+	checkForm(t, (*forms)[0], map[string]string{
+		"grant_type":    "refresh_token",
+		"refresh_token": "old-refresh",
+		"client_id":     "client-id",
+		"client_secret": "client-secret",
+	})
+
+	// The rotated refresh token is kept for next time
+	saved := readTestToken(t, config.TokenFile)
+	if saved.AccessToken != "new-access" || saved.RefreshToken != "new-refresh" || !saved.Expiry.After(time.Now()) {
+		t.Errorf("saved token = %+v, want the new one", saved)
+	}
+	if info, err := os.Stat(config.TokenFile); err != nil {
+		t.Error(err)
+	} else if info.Mode().Perm() != 0o600 {
+		t.Errorf("token file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// Until it expires, the saved token is used as it is
+	if access, err := config.AccessToken(context.Background()); err != nil || access != "new-access" {
+		t.Errorf("AccessToken = %q, %v, want new-access", access, err)
+	}
+	if len(*forms) != 1 {
+		t.Errorf("a valid token was refreshed")
+	}
+}
+
+func TestAccessTokenKeepsRefreshToken(t *testing.T) {
+	// Google doesn't rotate refresh tokens, so none is returned
+	server, _ := tokenEndpoint(t, map[string]any{"access_token": "new-access", "token_type": "Bearer", "expires_in": 3600})
+	config := testOAuthConfig(t, server.URL)
+
+	if err := config.writeToken(&oauth2.Token{AccessToken: "old-access", RefreshToken: "old-refresh", Expiry: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.AccessToken(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if saved := readTestToken(t, config.TokenFile); saved.RefreshToken != "old-refresh" {
+		t.Errorf("refresh token = %q, want old-refresh kept", saved.RefreshToken)
+	}
+}
+
+func TestAccessTokenWithoutTokenFile(t *testing.T) {
+	config := testOAuthConfig(t, "http://127.0.0.1:0")
+	if _, err := config.AccessToken(context.Background()); err == nil {
+		t.Error("AccessToken succeeded without a token file")
+	}
+}
+
+func mustQuery(t *testing.T, rawURL string) url.Values {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query()
+}
+
+// Visits the redirect URL of the authorization URL with the query.
+func redirect(t *testing.T, authURL string, query url.Values) int {
+	t.Helper()
+
+	resp, err := http.Get(mustQuery(t, authURL).Get("redirect_uri") + "?" + query.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAuthorizeLoopback(t *testing.T) {
+	server, forms := tokenEndpoint(t, map[string]any{
+		"access_token":  "access",
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+		"refresh_token": "refresh",
+	})
+	config := testOAuthConfig(t, server.URL)
+
+	err := config.AuthorizeLoopback(context.Background(), func(authURL string) {
+		state := mustQuery(t, authURL).Get("state")
+
+		if status := redirect(t, authURL, url.Values{"state": {"forged"}, "code": {"forged-code"}}); status != http.StatusBadRequest {
+			t.Errorf("a forged state got %d, want 400", status)
+		}
+		if status := redirect(t, authURL, url.Values{"state": {state}, "code": {"good-code"}}); status != http.StatusOK {
+			t.Errorf("the redirect got %d, want 200", status)
+		}
+
+		// A reload of the page doesn't hang, and the first code is kept
+		if status := redirect(t, authURL, url.Values{"state": {state}, "code": {"second-code"}}); status != http.StatusOK {
+			t.Errorf("the second redirect got %d, want 200", status)
+		}
+		if status := redirect(t, authURL, url.Values{"state": {state}, "error": {"access_denied"}}); status != http.StatusOK {
+			t.Errorf("a later error got %d, want 200", status)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*forms) != 1 {
+		t.Fatalf("token endpoint was called %d times, want once", len(*forms))
+	}
+	form := (*forms)[0]
+	checkForm(t, form, map[string]string{
+		"grant_type":    "authorization_code",
+		"code":          "good-code",
+		"client_id":     "client-id",
+		"client_secret": "client-secret",
+	})
+	if form.Get("code_verifier") == "" || form.Get("redirect_uri") == "" {
+		t.Errorf("form %v is missing the PKCE verifier or redirect URI", form)
+	}
+
+	if saved := readTestToken(t, config.TokenFile); saved.RefreshToken != "refresh" {
+		t.Errorf("saved refresh token = %q, want refresh", saved.RefreshToken)
+	}
+}
+
+func TestAuthorizeLoopbackRejectsState(t *testing.T) {
+	server, forms := tokenEndpoint(t, map[string]any{"access_token": "access", "token_type": "Bearer", "refresh_token": "refresh"})
+	config := testOAuthConfig(t, server.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	err := config.AuthorizeLoopback(ctx, func(authURL string) {
+		if status := redirect(t, authURL, url.Values{"state": {"forged"}, "code": {"forged-code"}}); status != http.StatusBadRequest {
+			t.Errorf("a forged state got %d, want 400", status)
+		}
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("AuthorizeLoopback = %v, want it still waiting for the real redirect", err)
+	}
+
+	if len(*forms) != 0 {
+		t.Errorf("the forged code was exchanged")
+	}
+	if _, err := os.Stat(config.TokenFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("a token file was written: %v", err)
+	}
+}
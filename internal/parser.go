@@ -4,15 +4,33 @@ package email
 
 import (
 	"fmt"
+	"os"
 	"strings"
 )
 
+// Columns of the input file. Files with a header row may put them in
+// any order; files without one must follow the order of defaultColumns.
+const (
+	ColumnName        = "name"
+	ColumnEmail       = "email"
+	ColumnAttachments = "attachments" // File paths separated by ";"
+	ColumnCC          = "cc"          // Addresses separated by ";"
+	ColumnBCC         = "bcc"         // Addresses separated by ";"
+	ColumnPassword    = "password"    // Shown in the Credentials email
+	ColumnLanguage    = "language"    // Locale of the templates, e.g. fil or zh
+)
+
+var defaultColumns = []string{ColumnName, ColumnEmail, ColumnAttachments, ColumnCC, ColumnBCC, ColumnPassword, ColumnLanguage}
+
 type ParseResult struct {
 	Invalids   int
 	Duplicates int
-	Raw        [][]string
+	Header     []string       // Header row, if the file has one
+	Columns    map[string]int // Index of each column in a record
+	Raw        [][]string     // Records, without the header row
 	Recipients []User
-	BadEmails  map[int]string
+	Rows       []int          // Index in Raw of each recipient
+	BadEmails  map[int]string // Why each record was skipped, by its number in Raw from 1
 }
 
 func (p ParseResult) IsEmpty() bool {
@@ -31,40 +49,211 @@ func ParseData(filepath string) ([][]string, error) {
 
 	ReadAll, err := NewReader(filepath)
 	if err != nil {
+		logger.Error("input read failed", "path", filepath, "error", err)
 		return [][]string{}, err
 	}
 
 	records, err := ReadAll()
 	if err != nil {
+		logger.Error("input read failed", "path", filepath, "error", err)
 		return [][]string{}, err
 	}
 
+	logger.Info("input read", "path", filepath, "rows", len(records))
+
 	return records, nil
 }
 
+// Returns the trimmed value of a column of the i-th record.
+func (p ParseResult) Field(i int, column string) string {
+	idx, ok := p.Columns[column]
+	if !ok || idx >= len(p.Raw[i]) {
+		return ""
+	}
+	return strings.TrimSpace(strings.ReplaceAll(p.Raw[i][idx], "\r", ""))
+}
+
+// Returns the row of the file that the i-th record is on, counting the
+// header row, as a spreadsheet numbers it.
+func (p ParseResult) Row(i int) int {
+	if p.Header != nil {
+		return i + 2
+	}
+	return i + 1
+}
+
+// Returns the recipient of the i-th record, whether it's valid or not.
+// Invalid CC and BCC addresses and unknown languages are left out.
+func (p ParseResult) Recipient(i int) User {
+	cc, _ := ParseUsers(p.Field(i, ColumnCC))
+	bcc, _ := ParseUsers(p.Field(i, ColumnBCC))
+	language, _ := ParseLocale(p.Field(i, ColumnLanguage))
+
+	return User{
+		Name:        p.Field(i, ColumnName),
+		Email:       p.Field(i, ColumnEmail),
+		Password:    p.Field(i, ColumnPassword),
+		Attachments: splitList(p.Field(i, ColumnAttachments)),
+		CC:          cc,
+		BCC:         bcc,
+		Language:    language,
+		Fields:      p.Fields(i),
+	}
+}
+
+// Returns every column of the i-th record by its header, or by the
+// default column names if the file has no header row.
+func (p ParseResult) Fields(i int) map[string]string {
+	fields := map[string]string{}
+	for idx, name := range p.columnNames() {
+		if name == "" {
+			continue
+		}
+
+		value := ""
+		if idx < len(p.Raw[i]) {
+			value = strings.TrimSpace(strings.ReplaceAll(p.Raw[i][idx], "\r", ""))
+		}
+		fields[name] = value
+	}
+
+	return fields
+}
+
+// Returns the names of the columns in To.Fields.
+func (p ParseResult) FieldNames() []string {
+	names := []string{}
+	for _, name := range p.columnNames() {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Returns the name of each column, or "" for the ones left out of
+// To.Fields: the password and columns without a header.
+func (p ParseResult) columnNames() []string {
+	header := p.Header
+	if header == nil {
+		header = defaultColumns
+	}
+
+	names := make([]string, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if !strings.EqualFold(name, ColumnPassword) {
+			names[i] = name
+		}
+	}
+	return names
+}
+
 func ValidateRecords(records [][]string) ParseResult {
 	recipientMap := map[string]int{}
-	result := ParseResult{Raw: records, BadEmails: make(map[int]string)}
+	result := ParseResult{BadEmails: make(map[int]string)}
+	result.Columns, result.Header = parseHeader(records)
+	if result.Header != nil {
+		records = records[1:]
+	}
+	result.Raw = records
 
-	for i, r := range records {
-		name := strings.TrimSpace(strings.ReplaceAll(r[0], "\r", ""))
-		email := strings.TrimSpace(strings.ReplaceAll(r[1], "\r", ""))
+	for i := range records {
+		recipient := result.Recipient(i)
+		email := recipient.Email
 
 		if !IsValidEmail(email) {
 			result.Invalids++
-			result.BadEmails[i+1] = fmt.Sprintf("Invalid email address at row %d (%s).", i+1, email)
+			result.BadEmails[i+1] = fmt.Sprintf("Invalid email address at row %d (%s).", result.Row(i), email)
 			continue
 		}
 		if dupeIdx, exists := recipientMap[email]; exists {
 			result.Duplicates++
-			result.BadEmails[i+1] = fmt.Sprintf("Duplicate email at row %d. Exact match at record %d (%s).", i+1, dupeIdx+1, email)
+			result.BadEmails[i+1] = fmt.Sprintf("Duplicate email at row %d. Exact match at row %d (%s).", result.Row(i), result.Row(dupeIdx), email)
 			continue
 		} else {
 			recipientMap[email] = i
 		}
 
-		result.Recipients = append(result.Recipients, User{Name: name, Email: email})
+		if _, err := ParseUsers(result.Field(i, ColumnCC)); err != nil {
+			result.Invalids++
+			result.BadEmails[i+1] = fmt.Sprintf("Invalid CC email at row %d (%s).", result.Row(i), result.Field(i, ColumnCC))
+			continue
+		}
+		if _, err := ParseUsers(result.Field(i, ColumnBCC)); err != nil {
+			result.Invalids++
+			result.BadEmails[i+1] = fmt.Sprintf("Invalid BCC email at row %d (%s).", result.Row(i), result.Field(i, ColumnBCC))
+			continue
+		}
+
+		if _, err := ParseLocale(result.Field(i, ColumnLanguage)); err != nil {
+			result.Invalids++
+			result.BadEmails[i+1] = fmt.Sprintf("Unknown language at row %d (%s).", result.Row(i), result.Field(i, ColumnLanguage))
+			continue
+		}
+
+		if missing := missingFile(recipient.Attachments); missing != "" {
+			result.Invalids++
+			result.BadEmails[i+1] = fmt.Sprintf("Attachment not found at row %d (%s).", result.Row(i), missing)
+			continue
+		}
+
+		result.Recipients = append(result.Recipients, recipient)
+		result.Rows = append(result.Rows, i)
+	}
+
+	for record, reason := range result.BadEmails {
+		logger.Warn("invalid record", "row", result.Row(record-1), "reason", reason)
 	}
 
+	logger.Info("records validated",
+		"rows", len(records),
+		"recipients", len(result.Recipients),
+		"invalids", result.Invalids,
+		"duplicates", result.Duplicates)
+
 	return result
 }
+
+// Finds the header row of the records and returns the index of each
+// column. Records without a header row use the default column order.
+func parseHeader(records [][]string) (map[string]int, []string) {
+	columns := map[string]int{}
+
+	if len(records) > 0 {
+		for i, cell := range records[0] {
+			cell = strings.TrimPrefix(cell, "\ufeff")
+			columns[strings.ToLower(strings.TrimSpace(cell))] = i
+		}
+		if _, ok := columns[ColumnEmail]; ok {
+			return columns, records[0]
+		}
+	}
+
+	columns = map[string]int{}
+	for i, column := range defaultColumns {
+		columns[column] = i
+	}
+	return columns, nil
+}
+
+// Splits a list of values separated by ";".
+func splitList(value string) []string {
+	values := []string{}
+	for _, v := range strings.Split(value, ";") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// Returns the first path that isn't an existing file.
+func missingFile(paths []string) string {
+	for _, path := range paths {
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestValidateRecordsRows(t *testing.T) {
+	records := [][]string{
+		{"Jane Doe", "jane@example.com"},
+		{"John Doe", "not an address"},
+		{"Jane Again", "jane@example.com"},
+		{"Mary Doe", "mary@example.com", "", "", "", "", "klingon"},
+	}
+	header := []string{"Name", "Email", "Attachments", "CC", "BCC", "Password", "Language"}
+
+	for name, test := range map[string]struct {
+		records [][]string
+		want    map[int]string
+	}{
+		"without header": {records, map[int]string{
+			2: "Invalid email address at row 2 (not an address).",
+			3: "Duplicate email at row 3. Exact match at row 1 (jane@example.com).",
+			4: "Unknown language at row 4 (klingon).",
+		}},
+		// The rows are the spreadsheet's, counting the header
+		"with header": {append([][]string{header}, records...), map[int]string{
+			2: "Invalid email address at row 3 (not an address).",
+			3: "Duplicate email at row 4. Exact match at row 2 (jane@example.com).",
+			4: "Unknown language at row 5 (klingon).",
+		}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			result := ValidateRecords(test.records)
+			if !maps.Equal(result.BadEmails, test.want) {
+				t.Errorf("BadEmails = %q, want %q", result.BadEmails, test.want)
+			}
+			if len(result.Recipients) != 1 || result.Recipients[0].Email != "jane@example.com" {
+				t.Errorf("Recipients = %v, want only Jane", result.Recipients)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	netmail "net/mail"
+	"strconv"
+	"strings"
+)
+
+// Sends messages through the Postmark API.
+type PostmarkTransport struct {
+	API APIConfig
+}
+
+// Postmark's error codes that need handling of their own.
+// https://postmarkapp.com/developer/api/overview#error-codes
+const (
+	postmarkBadToken         = 10
+	postmarkInvalidRequest   = 300
+	postmarkInactiveReceiver = 406
+)
+
+type postmarkEmail struct {
+	From          string
+	To            string
+	Cc            string `json:",omitempty"`
+	Bcc           string `json:",omitempty"`
+	ReplyTo       string `json:",omitempty"`
+	Subject       string
+	TextBody      string               `json:",omitempty"`
+	HtmlBody      string               `json:",omitempty"`
+	Headers       []postmarkHeader     `json:",omitempty"`
+	Attachments   []postmarkAttachment `json:",omitempty"`
+	MessageStream string               `json:",omitempty"`
+}
+
+type postmarkHeader struct {
+	Name  string
+	Value string
+}
+
+type postmarkAttachment struct {
+	Name        string
+	Content     string
+	ContentType string
+	ContentID   string `json:",omitempty"`
+}
+
+func (t PostmarkTransport) Send(ctx context.Context, raw RawMessage) (string, error) {
+	parsed, err := parseMessage(raw)
+	if err != nil {
+		return "", err
+	}
+
+	body := postmarkEmail{
+		From:          parsed.From.String(),
+		To:            joinAddresses(parsed.To),
+		Cc:            joinAddresses(parsed.Cc),
+		Bcc:           joinAddresses(parsed.Bcc),
+		ReplyTo:       joinAddresses(parsed.ReplyTo),
+		Subject:       parsed.Subject,
+		TextBody:      parsed.Text,
+		HtmlBody:      parsed.HTML,
+		MessageStream: t.API.Stream,
+	}
+
+	for name, value := range parsed.Headers {
+		body.Headers = append(body.Headers, postmarkHeader{Name: name, Value: value})
+	}
+
+	for _, a := range parsed.Attachments {
+		attachment := postmarkAttachment{
+			Name:        a.Name,
+			Content:     base64.StdEncoding.EncodeToString(a.Data),
+			ContentType: a.ContentType,
+		}
+		if a.ContentID != "" {
+			attachment.ContentID = "cid:" + a.ContentID
+		}
+		body.Attachments = append(body.Attachments, attachment)
+	}
+
+	req, err := newJSONRequest(ctx, t.API.BaseURL+"/email", body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Postmark-Server-Token", t.API.Key)
+
+	status, _, data, err := doRequest(req)
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		ErrorCode int
+		Message   string
+		MessageID string
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		response.Message = strings.TrimSpace(string(data))
+	}
+
+	if status >= 300 || response.ErrorCode != 0 {
+		return "", postmarkError(status, response.ErrorCode, response.Message, raw.Rcpts)
+	}
+
+	return fmt.Sprintf("%d %s, message id %s", status, response.Message, response.MessageID), nil
+}
+
+func postmarkError(status, code int, message string, rcpts []string) error {
+	kind, rejected := statusKind(status), []string{}
+
+	switch code {
+	case postmarkBadToken:
+		kind = ErrUnauthorized
+	case postmarkInvalidRequest, postmarkInactiveReceiver:
+		// Both name the recipients at fault in the message
+		rejected = recipientsIn(message, rcpts)
+	}
+
+	return newSendError(TransportPostmark, status, strconv.Itoa(code), message, kind, rejected)
+}
+
+func joinAddresses(addrs []*netmail.Address) string {
+	list := make([]string, len(addrs))
+	for i, addr := range addrs {
+		list[i] = addr.String()
+	}
+	return strings.Join(list, ", ")
+}
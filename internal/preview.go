@@ -0,0 +1,153 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"cmp"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Renders the HTML body of the email as plain text for the terminal.
+func (e Email) Text() (string, error) {
+	body, err := e.HTML()
+	if err != nil {
+		return "", err
+	}
+
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return body, nil
+	}
+
+	var sb strings.Builder
+	renderText(&sb, doc)
+
+	lines := []string{}
+	blank := true
+	for _, line := range strings.Split(sb.String(), "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" {
+			if !blank {
+				lines = append(lines, "")
+			}
+			blank = true
+			continue
+		}
+		lines = append(lines, line)
+		blank = false
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n")), nil
+}
+
+// Writes the rendered HTML body to a temporary file and returns its path.
+func (e Email) WritePreview() (string, error) {
+	body, err := e.HTML()
+	if err != nil {
+		return "", err
+	}
+
+	// Browsers can't show cid: images, which are parts of the email
+	for _, image := range e.Body.Images {
+		data, err := image.Read()
+		if err != nil {
+			return "", fmt.Errorf("image %s not found: %w", image.CID, err)
+		}
+		url := "data:" + cmp.Or(mime.TypeByExtension(filepath.Ext(image.Path)), "image/png") + ";base64," + base64.StdEncoding.EncodeToString(data)
+		body = strings.ReplaceAll(body, "cid:"+image.CID, url)
+	}
+
+	file, err := os.CreateTemp("", "email-preview-*.html")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(body); err != nil {
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+// Opens the given file or URL with the default browser.
+func OpenInBrowser(path string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", path)
+	case "darwin":
+		cmd = exec.Command("open", path)
+	default:
+		cmd = exec.Command("xdg-open", path)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to open browser: %w", err)
+	}
+
+	return cmd.Process.Release()
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+func renderText(sb *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		sb.WriteString(whitespace.ReplaceAllString(n.Data, " "))
+		return
+	case html.ElementNode:
+		switch n.Data {
+		case "head", "style", "script", "title":
+			return
+		case "br":
+			sb.WriteString("\n")
+			return
+		case "img":
+			if alt := attr(n, "alt"); alt != "" {
+				sb.WriteString("[" + alt + "]")
+			}
+			return
+		case "li":
+			sb.WriteString("\n  • ")
+		case "p", "div", "h1", "h2", "h3", "tr", "ul", "ol", "table":
+			sb.WriteString("\n")
+		}
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		renderText(sb, c)
+	}
+
+	if n.Type != html.ElementNode {
+		return
+	}
+
+	switch n.Data {
+	case "a":
+		if href := attr(n, "href"); href != "" && !strings.HasPrefix(href, "mailto:") {
+			sb.WriteString(" (" + href + ")")
+		}
+	case "p", "div", "h1", "h2", "h3", "tr", "ul", "ol", "table":
+		sb.WriteString("\n")
+	}
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,637 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const jobFile = "job.json"
+
+// The delivery state of a queued message.
+type State string
+
+const (
+	Pending   State = "pending"
+	Sending   State = "sending"
+	Sent      State = "sent"
+	Failed    State = "failed"
+	Cancelled State = "cancelled"
+)
+
+// A batch of rendered messages queued on disk. Every batch is written
+// to the queue before anything is sent, and each message's state is
+// updated as it goes, so an interrupted batch can be resumed.
+type Job struct {
+	ID        string          `json:"id"`
+	Template  string          `json:"template"`
+	Scheduled bool            `json:"scheduled"`
+	SendAt    time.Time       `json:"send_at"`
+	CreatedAt time.Time       `json:"created_at"`
+	Messages  []QueuedMessage `json:"messages"`
+
+	unlock func() // Set while this process holds the job's send lock
+}
+
+// A rendered message of a queued job.
+type QueuedMessage struct {
+	To        User       `json:"to"`
+	File      string     `json:"file"`
+	From      string     `json:"from"`
+	Rcpts     []string   `json:"rcpts"`
+	State     State      `json:"state"`
+	MessageID string     `json:"message_id"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+	Response  string     `json:"response,omitempty"`
+	Error     string     `json:"error,omitempty"`
+
+	// The recipients the provider refused, if that's why it failed
+	Rejected []Rejection `json:"rejected,omitempty"`
+}
+
+// Returns why the message failed: the recipients the provider refused,
+// e.g. "rejected jane@example.com (406)", or else the error.
+func (m QueuedMessage) Reason() string {
+	if len(m.Rejected) > 0 {
+		return "rejected " + joinRejections(m.Rejected)
+	}
+	return m.Error
+}
+
+func joinRejections(rejected []Rejection) string {
+	list := make([]string, len(rejected))
+	for i, r := range rejected {
+		list[i] = r.String()
+	}
+	return strings.Join(list, ", ")
+}
+
+// Returns the directory where jobs are queued.
+func QueueDir() string {
+	if dir := os.Getenv("QUEUE_DIR"); dir != "" {
+		return dir
+	}
+	return "queue"
+}
+
+// Reports whether every message of the job was sent, failed or was
+// cancelled.
+func (j Job) Done() bool {
+	for _, m := range j.Messages {
+		if m.State == Pending || m.State == Sending {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the number of messages that were sent and that failed.
+// Cancelled messages count as neither.
+func (j Job) Count() (sent, failed int) {
+	for _, m := range j.Messages {
+		switch m.State {
+		case Sent:
+			sent++
+		case Failed:
+			failed++
+		}
+	}
+	return sent, failed
+}
+
+// Renders the template for every recipient and queues the messages
+// on disk to be sent at the given time by the scheduler.
+func ScheduleBatch(templateName string, recipients []User, config EmailConfig, sendAt time.Time) (Job, error) {
+	template, err := config.Template(templateName)
+	if err != nil {
+		return Job{}, err
+	}
+
+	return QueueBatch(templateName, template, recipients, config, sendAt, true)
+}
+
+// Renders the template for every recipient and writes the batch to the
+// queue. Scheduled batches are sent by the scheduler once they're due,
+// the others are expected to be sent right away with SendNext, and are
+// returned locked until Unlock is called.
+func QueueBatch(templateName string, template Template, recipients []User, config EmailConfig, sendAt time.Time, scheduled bool) (Job, error) {
+	if len(recipients) == 0 {
+		return Job{}, fmt.Errorf("no recipients to schedule")
+	}
+
+	id, err := newJobID(sendAt)
+	if err != nil {
+		return Job{}, err
+	}
+
+	// The batch is rendered in a hidden folder and moved into the queue
+	// once it's complete, so the scheduler never finds it half written
+	dir := filepath.Join(QueueDir(), id)
+	tmp := filepath.Join(QueueDir(), ".queueing-"+id)
+	if err := os.MkdirAll(tmp, 0o700); err != nil {
+		return Job{}, err
+	}
+
+	job := Job{
+		ID:        id,
+		Template:  templateName,
+		Scheduled: scheduled,
+		SendAt:    sendAt,
+		CreatedAt: time.Now(),
+	}
+
+	// The password links of emails rendered so far go too if the batch
+	// isn't queued, so none are left working
+	var emails []Email
+	fail := func(err error) (Job, error) {
+		os.RemoveAll(tmp)
+		for _, em := range emails {
+			em.removePasswordLink()
+		}
+		return Job{}, err
+	}
+
+	for i, r := range recipients {
+		em := Email{Body: template, To: r, Config: config, job: id, sendAt: sendAt}
+		if err := em.createPasswordLink(); err != nil {
+			return fail(fmt.Errorf("failed to render email for %s: %w", r.Email, err))
+		}
+		emails = append(emails, em)
+
+		queued, err := queueMessage(em, templateName, tmp, fmt.Sprintf("%03d.eml", i+1))
+		if err != nil {
+			return fail(fmt.Errorf("failed to render email for %s: %w", r.Email, err))
+		}
+
+		job.Messages = append(job.Messages, queued)
+	}
+
+	if err := writeJobIn(tmp, job); err != nil {
+		return fail(err)
+	}
+
+	// A batch that's sent right away is locked before it's in the queue,
+	// so that resume can't take it up first
+	if !scheduled {
+		if _, err := createLock(filepath.Join(tmp, sendLockFile), sendLockStale); err != nil {
+			return fail(err)
+		}
+	}
+
+	if err := os.Rename(tmp, dir); err != nil {
+		return fail(err)
+	}
+	if !scheduled {
+		job.unlock = holdLock(filepath.Join(dir, sendLockFile))
+	}
+
+	logger.Info("batch queued",
+		"job", job.ID,
+		"template", templateName,
+		"messages", len(job.Messages),
+		"scheduled", scheduled,
+		"send_at", sendAt)
+
+	return job, nil
+}
+
+// Returns every queued job, ordered by send time. Folders in the queue
+// that don't hold a readable job are logged and skipped.
+func ListJobs() ([]Job, error) {
+	entries, err := os.ReadDir(QueueDir())
+	if os.IsNotExist(err) {
+		return []Job{}, nil
+	}
+	if err != nil {
+		return []Job{}, err
+	}
+
+	jobs := []Job{}
+	for _, entry := range entries {
+		// Batches that are still being queued are in hidden folders
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		job, err := readJob(entry.Name())
+		if err != nil {
+			logger.Warn("queue folder skipped", "dir", entry.Name(), "error", err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].SendAt.Before(jobs[k].SendAt) })
+
+	return jobs, nil
+}
+
+// The IDs given by newJobID, e.g. 20261103-0730-a1b2c3.
+var jobIDPattern = regexp.MustCompile(`^\d{8}-\d{4}-[0-9a-f]{6}$`)
+
+// Cancels the messages of a job that weren't sent yet. The job is kept
+// in the queue with them marked as cancelled.
+func CancelJob(id string) error {
+	if !jobIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid job id %q", id)
+	}
+
+	cancelled := []QueuedMessage{}
+	_, err := updateJob(id, func(job *Job) error {
+		if job.Done() {
+			return fmt.Errorf("job %s has nothing left to send", id)
+		}
+		cancelled = job.cancelPending()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Info("batch cancelled", "job", id, "not_sent", len(cancelled))
+	return nil
+}
+
+// Returns the index of the message SendNext sends next, or -1 if there
+// is nothing left to send.
+func (j Job) Next() int {
+	for i, m := range j.Messages {
+		if m.State == Pending {
+			return i
+		}
+	}
+	return -1
+}
+
+// Sends the next pending message of the job and returns it with its new
+// state. It returns false once there is nothing left to send, or with the
+// context's error if it was cancelled before the next message.
+//
+// The message is marked as sending before it is handed to the server, so
+// if the program dies midway it's never sent a second time on resume.
+// A message that was already handed to the server is always finished,
+// even if the context is cancelled meanwhile.
+func (j *Job) SendNext(ctx context.Context, transport Transport) (QueuedMessage, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return QueuedMessage{}, false, err
+	}
+
+	// Other processes can only cancel messages, so a job with nothing
+	// pending here has nothing pending on disk either
+	if j.Next() < 0 {
+		return QueuedMessage{}, false, nil
+	}
+
+	// The job is read again first, since it may have been cancelled
+	// by another process
+	i := -1
+	job, err := updateJob(j.ID, func(job *Job) error {
+		if i = job.Next(); i >= 0 {
+			job.Messages[i].State = Sending
+		}
+		return nil
+	})
+	if err != nil {
+		return QueuedMessage{}, false, err
+	}
+	j.refresh(job)
+	if i < 0 {
+		return QueuedMessage{}, false, nil
+	}
+
+	response, sendErr := sendQueued(context.WithoutCancel(ctx), transport, *j, j.Messages[i])
+	finish := func(m *QueuedMessage) {
+		m.Response = response
+		if sendErr != nil {
+			m.State = Failed
+			m.Error = sendErr.Error()
+			m.Rejected = Rejections(sendErr)
+		} else {
+			sentAt := time.Now()
+			m.State = Sent
+			m.SentAt = &sentAt
+		}
+	}
+
+	finish(&j.Messages[i])
+	job, err = updateJob(j.ID, func(job *Job) error {
+		finish(&job.Messages[i])
+		return nil
+	})
+	if err != nil {
+		return j.Messages[i], true, err
+	}
+	j.refresh(job)
+
+	return j.Messages[i], true, pruneSent(*j)
+}
+
+// Removes a batch that was sent right away from the queue once every
+// message of it was sent. Scheduled batches, and batches with failed or
+// cancelled messages, are kept so that "schedule list" shows them.
+func pruneSent(j Job) error {
+	if j.Scheduled {
+		return nil
+	}
+	for _, m := range j.Messages {
+		if m.State != Sent {
+			return nil
+		}
+	}
+	return os.RemoveAll(filepath.Join(QueueDir(), j.ID))
+}
+
+// Marks every pending message of the job as cancelled, so that it's
+// neither sent by the scheduler nor on resume, and returns them.
+func (j *Job) CancelPending() ([]QueuedMessage, error) {
+	cancelled := j.cancelPending()
+	job, err := updateJob(j.ID, func(job *Job) error {
+		cancelled = job.cancelPending()
+		return nil
+	})
+	if err != nil {
+		return cancelled, err
+	}
+	j.refresh(job)
+
+	logger.Info("batch cancelled", "job", j.ID, "not_sent", len(cancelled))
+
+	return cancelled, nil
+}
+
+// Replaces the job with the one read from disk, keeping its lock.
+func (j *Job) refresh(job Job) {
+	job.unlock = j.unlock
+	*j = job
+}
+
+func (j *Job) cancelPending() []QueuedMessage {
+	cancelled := []QueuedMessage{}
+	for i := range j.Messages {
+		if j.Messages[i].State == Pending {
+			j.Messages[i].State = Cancelled
+			cancelled = append(cancelled, j.Messages[i])
+		}
+	}
+	return cancelled
+}
+
+// Sends every pending message of the job. The report function is called
+// once for each message that was sent or failed. If the context is
+// cancelled, the rest of the messages are left pending for resume.
+// It returns ErrJobLocked if another process is sending the job.
+func SendJob(ctx context.Context, transport Transport, job *Job, report func(Job, QueuedMessage)) error {
+	if job.unlock == nil {
+		err := job.Lock()
+		if errors.Is(err, os.ErrNotExist) {
+			// Another process sent it and removed it from the queue meanwhile
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer job.Unlock()
+	}
+
+	// Messages left in the sending state were interrupted and may already
+	// be in the recipient's inbox, so they are not sent again.
+	interrupted := []int{}
+	updated, err := updateJob(job.ID, func(job *Job) error {
+		interrupted = interrupted[:0]
+		for i, m := range job.Messages {
+			if m.State == Sending {
+				job.Messages[i].State = Failed
+				job.Messages[i].Error = "interrupted while sending, check with the recipient before sending again"
+				interrupted = append(interrupted, i)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	job.refresh(updated)
+
+	for _, i := range interrupted {
+		m := job.Messages[i]
+		logger.Warn("interrupted send not retried", "job", job.ID, "recipient", m.To.Email, "message_id", m.MessageID)
+		if report != nil {
+			report(*job, m)
+		}
+	}
+
+	for {
+		m, ok, err := job.SendNext(ctx, transport)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if report != nil {
+			report(*job, m)
+		}
+	}
+}
+
+// Sends every scheduled job that is due at the given time.
+func RunDueJobs(ctx context.Context, transport Transport, now time.Time, report func(Job, QueuedMessage)) error {
+	jobs, err := ListJobs()
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if !job.Scheduled || job.Done() || job.SendAt.After(now) {
+			continue
+		}
+
+		err := SendJob(ctx, transport, &job, report)
+		if errors.Is(err, ErrJobLocked) {
+			logger.Info("job skipped", "job", job.ID, "reason", err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Finishes the interrupted batches in the queue, or only the one with the
+// given ID. Scheduled jobs that aren't due yet are left to the scheduler,
+// and batches that another process is sending are refused.
+func ResumeJobs(ctx context.Context, transport Transport, id string, report func(Job, QueuedMessage)) error {
+	jobs, err := ListJobs()
+	if err != nil {
+		return err
+	}
+
+	found := false
+	locked := []error{}
+	for _, job := range jobs {
+		if id != "" && job.ID != id {
+			continue
+		}
+		found = true
+
+		if job.Done() || (job.Scheduled && job.SendAt.After(time.Now())) {
+			continue
+		}
+
+		err := SendJob(ctx, transport, &job, report)
+		if errors.Is(err, ErrJobLocked) {
+			locked = append(locked, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if id != "" && !found {
+		return fmt.Errorf("job %s not found", id)
+	}
+
+	return errors.Join(locked...)
+}
+
+// Checks the queue every interval and sends the jobs that are due
+// until the context is cancelled.
+func RunScheduler(ctx context.Context, transport Transport, interval time.Duration, report func(Job, QueuedMessage)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := RunDueJobs(ctx, transport, time.Now(), report); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Renders the email into an EML file in dir, dated at the time it will be sent.
+func queueMessage(em Email, template, dir, file string) (QueuedMessage, error) {
+	message, err := em.Message()
+	if err != nil {
+		return QueuedMessage{}, err
+	}
+	message.SetDateWithValue(em.sendAt)
+
+	raw, err := render(template, message)
+	if err != nil {
+		return QueuedMessage{}, err
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, file), raw.Data, 0o600); err != nil {
+		return QueuedMessage{}, err
+	}
+
+	return QueuedMessage{
+		To:        em.To,
+		File:      file,
+		From:      raw.From,
+		Rcpts:     raw.Rcpts,
+		State:     Pending,
+		MessageID: raw.MessageID,
+	}, nil
+}
+
+// Sends the queued EML file as is, so the recipient gets exactly what was rendered.
+func sendQueued(ctx context.Context, transport Transport, job Job, m QueuedMessage) (string, error) {
+	data, err := os.ReadFile(filepath.Join(QueueDir(), job.ID, m.File))
+	if err != nil {
+		return "", err
+	}
+
+	return deliverRaw(ctx, transport, RawMessage{
+		Template:  job.Template,
+		MessageID: m.MessageID,
+		From:      m.From,
+		Rcpts:     m.Rcpts,
+		Data:      data,
+	})
+}
+
+func readJob(id string) (Job, error) {
+	data, err := os.ReadFile(filepath.Join(QueueDir(), id, jobFile))
+	if err != nil {
+		return Job{}, fmt.Errorf("job %s not found", id)
+	}
+
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return Job{}, fmt.Errorf("job %s is corrupted: %w", id, err)
+	}
+	upgradeJob(&job)
+
+	return job, nil
+}
+
+// Fills in the states of a job queued before messages had one. Those
+// jobs could only be scheduled, and a message was either sent, failed
+// or still pending.
+func upgradeJob(job *Job) {
+	for i := range job.Messages {
+		m := &job.Messages[i]
+		if m.State != "" {
+			continue
+		}
+
+		job.Scheduled = true
+		switch {
+		case m.SentAt != nil:
+			m.State = Sent
+		case m.Error != "":
+			m.State = Failed
+		default:
+			m.State = Pending
+		}
+	}
+}
+
+// Writes the job file atomically so that a crash never leaves it half written.
+func writeJob(job Job) error {
+	return writeJobIn(filepath.Join(QueueDir(), job.ID), job)
+}
+
+func writeJobIn(dir string, job Job) error {
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, jobFile)
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+func newJobID(sendAt time.Time) (string, error) {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return sendAt.Format("20060102-1504") + "-" + hex.EncodeToString(b), nil
+}
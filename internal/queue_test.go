@@ -0,0 +1,377 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+var queueConfig = EmailConfig{From: User{Name: "Monitoring", Email: "monitoring@example.com"}}
+
+// Queues the Late email to each address in a temporary queue.
+func testJob(t *testing.T, scheduled bool, sendAt time.Time, addresses ...string) Job {
+	t.Helper()
+
+	if os.Getenv("QUEUE_DIR") == "" {
+		t.Setenv("QUEUE_DIR", t.TempDir())
+	}
+
+	recipients := []User{}
+	for _, address := range addresses {
+		recipients = append(recipients, User{Email: address})
+	}
+
+	job, err := QueueBatch("LATE", Late, recipients, queueConfig, sendAt, scheduled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(job.Unlock)
+	return job
+}
+
+// Leaves the job's send lock as a process that died while sending it
+// would, or as one that's still sending it if fresh is true.
+func leaveLock(t *testing.T, job *Job, fresh bool) {
+	t.Helper()
+
+	job.Unlock()
+	path := filepath.Join(QueueDir(), job.ID, sendLockFile)
+	if err := os.WriteFile(path, []byte("12345\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if !fresh {
+		old := time.Now().Add(-2 * sendLockStale)
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Returns the addresses the transport sent to, in order.
+func sentTo(transport *MemoryTransport) []string {
+	to := []string{}
+	for _, raw := range transport.Messages() {
+		to = append(to, raw.Rcpts...)
+	}
+	return to
+}
+
+func states(job Job) []State {
+	list := []State{}
+	for _, m := range job.Messages {
+		list = append(list, m.State)
+	}
+	return list
+}
+
+func TestResumeAfterCrash(t *testing.T) {
+	job := testJob(t, false, time.Now(), "jane@example.com", "john@example.com", "mary@example.com")
+
+	// The program died while handing Jane's email to the server
+	job.Messages[0].State = Sending
+	if err := writeJob(job); err != nil {
+		t.Fatal(err)
+	}
+	leaveLock(t, &job, false)
+
+	transport := &MemoryTransport{}
+	reported := []string{}
+	report := func(_ Job, m QueuedMessage) { reported = append(reported, m.To.Email+" "+string(m.State)) }
+
+	if err := ResumeJobs(context.Background(), transport, "", report); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"john@example.com", "mary@example.com"}; !slices.Equal(sentTo(transport), want) {
+		t.Errorf("sent to %v, want %v", sentTo(transport), want)
+	}
+	if want := []string{"jane@example.com failed", "john@example.com sent", "mary@example.com sent"}; !slices.Equal(reported, want) {
+		t.Errorf("reported %v, want %v", reported, want)
+	}
+
+	saved, err := readJob(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []State{Failed, Sent, Sent}; !slices.Equal(states(saved), want) {
+		t.Errorf("states = %v, want %v", states(saved), want)
+	}
+	if saved.Messages[0].Error == "" || saved.Messages[0].SentAt != nil {
+		t.Errorf("the interrupted message = %+v, want it failed with a reason", saved.Messages[0])
+	}
+
+	// Resuming again sends nothing more
+	if err := ResumeJobs(context.Background(), transport, job.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(transport.Messages()); n != 2 {
+		t.Errorf("sent %d messages after resuming twice, want 2", n)
+	}
+}
+
+func TestResumeLeavesFutureJobs(t *testing.T) {
+	job := testJob(t, true, time.Now().Add(time.Hour), "jane@example.com")
+
+	transport := &MemoryTransport{}
+	if err := ResumeJobs(context.Background(), transport, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(transport.Messages()); n != 0 {
+		t.Errorf("sent %d messages of a job that isn't due", n)
+	}
+	if err := ResumeJobs(context.Background(), transport, "20260101-0000-000000", nil); err == nil {
+		t.Error("resumed a job that doesn't exist")
+	}
+
+	if saved, err := readJob(job.ID); err != nil || saved.Done() {
+		t.Errorf("job = %+v, %v, want it still pending", saved, err)
+	}
+}
+
+func TestSendNextPrunesSentBatches(t *testing.T) {
+	transport := &MemoryTransport{}
+
+	sent := testJob(t, false, time.Now(), "jane@example.com", "john@example.com")
+	if err := SendJob(context.Background(), transport, &sent, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(QueueDir(), sent.ID)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("a sent batch was kept in the queue: %v", err)
+	}
+
+	// Failed and scheduled batches are kept
+	failed := testJob(t, false, time.Now(), "jane@example.com")
+	scheduled := testJob(t, true, time.Now(), "jane@example.com")
+	if err := SendJob(context.Background(), &MemoryTransport{Err: errors.New("mailbox full")}, &failed, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := SendJob(context.Background(), transport, &scheduled, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, job := range []Job{failed, scheduled} {
+		saved, err := readJob(job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !saved.Done() {
+			t.Errorf("job %s = %v, want it done", job.ID, states(saved))
+		}
+	}
+}
+
+func TestRunDueJobsUpgradesOldJobs(t *testing.T) {
+	job := testJob(t, true, time.Now().Add(-time.Minute), "jane@example.com", "john@example.com", "mary@example.com")
+
+	// Rewrite the job as it was queued before messages had a state:
+	// Jane's was sent, John's failed and Mary's is still pending
+	var old map[string]any
+	data, _ := os.ReadFile(filepath.Join(QueueDir(), job.ID, jobFile))
+	if err := json.Unmarshal(data, &old); err != nil {
+		t.Fatal(err)
+	}
+	delete(old, "scheduled")
+	messages := old["messages"].([]any)
+	for _, m := range messages {
+		delete(m.(map[string]any), "state")
+		delete(m.(map[string]any), "message_id")
+	}
+	messages[0].(map[string]any)["sent_at"] = time.Now().Add(-time.Hour)
+	messages[1].(map[string]any)["error"] = "mailbox full"
+	data, _ = json.Marshal(old)
+	if err := os.WriteFile(filepath.Join(QueueDir(), job.ID, jobFile), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := readJob(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.Scheduled || saved.Done() {
+		t.Errorf("old job read as scheduled %v, done %v, want a scheduled job still pending", saved.Scheduled, saved.Done())
+	}
+	if want := []State{Sent, Failed, Pending}; !slices.Equal(states(saved), want) {
+		t.Errorf("states = %v, want %v", states(saved), want)
+	}
+
+	transport := &MemoryTransport{}
+	if err := RunDueJobs(context.Background(), transport, time.Now(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"mary@example.com"}; !slices.Equal(sentTo(transport), want) {
+		t.Errorf("sent to %v, want %v", sentTo(transport), want)
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	job := testJob(t, true, time.Now().Add(time.Hour), "jane@example.com", "john@example.com")
+
+	for _, id := range []string{"", "../" + job.ID, job.ID + "/..", `..\` + job.ID, "..", "20261103-0730-A1B2C3"} {
+		if err := CancelJob(id); err == nil {
+			t.Errorf("CancelJob(%q) was accepted", id)
+		}
+	}
+
+	if err := CancelJob(job.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The job is kept, with nothing left to send
+	saved, err := readJob(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []State{Cancelled, Cancelled}; !slices.Equal(states(saved), want) {
+		t.Errorf("states = %v, want %v", states(saved), want)
+	}
+	if err := CancelJob(job.ID); err == nil {
+		t.Error("a cancelled job was cancelled again")
+	}
+
+	transport := &MemoryTransport{}
+	if err := RunDueJobs(context.Background(), transport, time.Now().Add(2*time.Hour), nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(transport.Messages()); n != 0 {
+		t.Errorf("sent %d messages of a cancelled job", n)
+	}
+}
+
+func TestCancelWhileSending(t *testing.T) {
+	job := testJob(t, true, time.Now().Add(-time.Minute), "jane@example.com", "john@example.com", "mary@example.com")
+	transport := &MemoryTransport{}
+
+	// The scheduler has sent Jane's email when the job is cancelled
+	if _, _, err := job.SendNext(context.Background(), transport); err != nil {
+		t.Fatal(err)
+	}
+	if err := CancelJob(job.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SendJob(context.Background(), transport, &job, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"jane@example.com"}; !slices.Equal(sentTo(transport), want) {
+		t.Errorf("sent to %v, want %v", sentTo(transport), want)
+	}
+
+	saved, err := readJob(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []State{Sent, Cancelled, Cancelled}; !slices.Equal(states(saved), want) {
+		t.Errorf("states = %v, want %v", states(saved), want)
+	}
+}
+
+func TestListJobsSkipsIncompleteFolders(t *testing.T) {
+	job := testJob(t, true, time.Now().Add(-time.Minute), "jane@example.com")
+
+	// A batch still being queued, one that never got its job file, and
+	// a folder that isn't a job at all
+	for _, dir := range []string{".queueing-20261103-0730-a1b2c3", "20261103-0730-d4e5f6", "stray"} {
+		if err := os.MkdirAll(filepath.Join(QueueDir(), dir), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	jobs, err := ListJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != job.ID {
+		t.Errorf("ListJobs = %v, want only %s", jobs, job.ID)
+	}
+
+	transport := &MemoryTransport{}
+	if err := RunDueJobs(context.Background(), transport, time.Now(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(transport.Messages()); n != 1 {
+		t.Errorf("sent %d messages, want 1", n)
+	}
+}
+
+func TestQueueBatchFailureLeavesNothing(t *testing.T) {
+	t.Setenv("QUEUE_DIR", t.TempDir())
+
+	recipients := []User{{Email: "jane@example.com"}, {Email: "john@example.com", Attachments: []string{"missing.pdf"}}}
+	if _, err := QueueBatch("LATE", Late, recipients, queueConfig, time.Now(), true); err == nil {
+		t.Fatal("a batch with a missing attachment was queued")
+	}
+
+	if entries, _ := os.ReadDir(QueueDir()); len(entries) != 0 {
+		t.Errorf("the queue has %d entries, want none", len(entries))
+	}
+
+	// Nor are the password links of the emails rendered before it failed
+	// left working
+	config := queueConfig
+	config.SecretLinks = &SecretLinks{BaseURL: "https://secrets.example.com", Dir: t.TempDir(), TTL: time.Hour}
+	if _, err := QueueBatch("CRED", Credentials, recipients, config, time.Now(), true); err == nil {
+		t.Fatal("a batch with a missing attachment was queued")
+	}
+	if entries, _ := os.ReadDir(config.SecretLinks.Dir); len(entries) != 0 {
+		t.Errorf("%d password links were left behind, want none", len(entries))
+	}
+}
+
+func TestResumeRefusesLockedJobs(t *testing.T) {
+	job := testJob(t, true, time.Now().Add(-time.Minute), "jane@example.com")
+	leaveLock(t, &job, true)
+
+	transport := &MemoryTransport{}
+	if err := ResumeJobs(context.Background(), transport, job.ID, nil); !errors.Is(err, ErrJobLocked) {
+		t.Errorf("ResumeJobs = %v, want ErrJobLocked", err)
+	}
+	if err := ResumeJobs(context.Background(), transport, "", nil); !errors.Is(err, ErrJobLocked) {
+		t.Errorf("ResumeJobs of every job = %v, want ErrJobLocked", err)
+	}
+
+	// The scheduler leaves it to the other process
+	if err := RunDueJobs(context.Background(), transport, time.Now(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(transport.Messages()); n != 0 {
+		t.Errorf("sent %d messages of a job another process is sending", n)
+	}
+}
+
+func TestConcurrentResumesSendOnce(t *testing.T) {
+	addresses := []string{}
+	for i := range 20 {
+		addresses = append(addresses, fmt.Sprintf("intern%02d@example.com", i))
+	}
+	job := testJob(t, false, time.Now(), addresses...)
+	job.Unlock()
+
+	// Each resume stands in for a separate process
+	transport := &MemoryTransport{}
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := ResumeJobs(context.Background(), transport, "", nil); err != nil && !errors.Is(err, ErrJobLocked) {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	sent := sentTo(transport)
+	slices.Sort(sent)
+	if !slices.Equal(sent, addresses) {
+		t.Errorf("sent to %v, want each intern once", sent)
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// Backends that secrets such as SMTP_PASS can be stored in, set with
+// SECRETS_BACKEND.
+const (
+	SecretsEnv     = "env"     // Plaintext in the environment or .env
+	SecretsVault   = "vault"   // Encrypted file unlocked by a passphrase
+	SecretsKeyring = "keyring" // The system keyring
+)
+
+// Where secrets are read from and written to.
+type SecretStore interface {
+	// Returns the secret, or "" if it isn't set.
+	Get(key string) (string, error)
+	Set(key, value string) error
+}
+
+// Returns the store of the given backend, or the one set in
+// SECRETS_BACKEND if backend is empty.
+func OpenSecretStore(backend string) (SecretStore, error) {
+	if backend == "" {
+		backend = getenv("SECRETS_BACKEND", SecretsEnv)
+	}
+
+	switch strings.ToLower(backend) {
+	case SecretsEnv:
+		return envStore{path: ".env"}, nil
+	case SecretsVault:
+		return &vaultStore{path: getenv("VAULT_FILE", "secrets.vault")}, nil
+	case SecretsKeyring:
+		return keyringStore{service: getenv("KEYRING_SERVICE", "email-helper")}, nil
+	}
+	return nil, fmt.Errorf("unknown SECRETS_BACKEND %q", backend)
+}
+
+// Reads secrets from the environment and writes them to the .env file.
+type envStore struct {
+	path string
+}
+
+func (s envStore) Get(key string) (string, error) {
+	return os.Getenv(key), nil
+}
+
+// Replaces the key's line in the .env file, or appends one, leaving
+// the rest of the file as it is.
+func (s envStore) Set(key, value string) error {
+	line, err := godotenv.Marshal(map[string]string{key: value})
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	lines := []string{}
+	replaced := false
+	for _, l := range strings.Split(strings.TrimRight(string(data), "\r\n"), "\n") {
+		name, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(l), "export "), "=")
+		if strings.TrimSpace(name) == key {
+			if !replaced {
+				lines = append(lines, line)
+			}
+			replaced = true
+			continue
+		}
+		if l != "" || len(lines) > 0 {
+			lines = append(lines, l)
+		}
+	}
+	if !replaced {
+		lines = append(lines, line)
+	}
+
+	return os.WriteFile(s.path, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+}
+
+// Reads and writes secrets in the system keyring: the Windows
+// Credential Manager, the macOS Keychain or the Secret Service on Linux.
+type keyringStore struct {
+	service string
+}
+
+func (s keyringStore) Get(key string) (string, error) {
+	value, err := keyring.Get(s.service, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s from the keyring: %w", key, err)
+	}
+	return value, nil
+}
+
+func (s keyringStore) Set(key, value string) error {
+	if err := keyring.Set(s.service, key, value); err != nil {
+		return fmt.Errorf("failed to write %s to the keyring: %w", key, err)
+	}
+	return nil
+}
+
+// Keeps secrets in a file encrypted with AES-256-GCM, under a key
+// derived from a passphrase with scrypt. The passphrase is read from
+// VAULT_PASSPHRASE, or asked for on the terminal.
+type vaultStore struct {
+	path       string
+	passphrase string
+	secrets    map[string]string
+}
+
+type vaultFile struct {
+	Salt  []byte
+	Nonce []byte
+	Data  []byte
+}
+
+func (s *vaultStore) Get(key string) (string, error) {
+	if err := s.unlock(false); err != nil {
+		return "", err
+	}
+	return s.secrets[key], nil
+}
+
+func (s *vaultStore) Set(key, value string) error {
+	if err := s.unlock(true); err != nil {
+		return err
+	}
+	s.secrets[key] = value
+	return s.save()
+}
+
+// Decrypts the vault file, or starts an empty vault if there's none
+// and create is set.
+func (s *vaultStore) unlock(create bool) error {
+	if s.secrets != nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		if !create {
+			return fmt.Errorf("no vault at %s, run config set-password first", s.path)
+		}
+		return s.create()
+	}
+	if err != nil {
+		return err
+	}
+
+	var file vaultFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid vault file: %w", err)
+	}
+
+	passphrase, err := vaultPassphrase("Vault passphrase: ")
+	if err != nil {
+		return err
+	}
+
+	gcm, err := vaultCipher(passphrase, file.Salt)
+	if err != nil {
+		return err
+	}
+
+	plain, err := gcm.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		return fmt.Errorf("wrong vault passphrase")
+	}
+
+	secrets := map[string]string{}
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return fmt.Errorf("invalid vault file: %w", err)
+	}
+
+	s.passphrase, s.secrets = passphrase, secrets
+	return nil
+}
+
+func (s *vaultStore) create() error {
+	passphrase, err := vaultPassphrase("New vault passphrase: ")
+	if err != nil {
+		return err
+	}
+
+	if os.Getenv("VAULT_PASSPHRASE") == "" {
+		confirm, err := ReadSecret("Confirm passphrase: ")
+		if err != nil {
+			return err
+		}
+		if confirm != passphrase {
+			return fmt.Errorf("passphrases don't match")
+		}
+	}
+
+	s.passphrase, s.secrets = passphrase, map[string]string{}
+	return nil
+}
+
+// Encrypts the secrets with a new salt and nonce and writes the vault
+// file atomically.
+func (s *vaultStore) save() error {
+	plain, err := json.Marshal(s.secrets)
+	if err != nil {
+		return err
+	}
+
+	file := vaultFile{Salt: make([]byte, 16)}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return err
+	}
+
+	gcm, err := vaultCipher(s.passphrase, file.Salt)
+	if err != nil {
+		return err
+	}
+
+	file.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+	file.Data = gcm.Seal(nil, file.Nonce, plain, nil)
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(s.path); dir != "." {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+func vaultCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func vaultPassphrase(prompt string) (string, error) {
+	if passphrase := os.Getenv("VAULT_PASSPHRASE"); passphrase != "" {
+		return passphrase, nil
+	}
+
+	passphrase, err := ReadSecret(prompt)
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("no vault passphrase given")
+	}
+
+	return passphrase, nil
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// Asks for a secret on the terminal without echoing it. Input that
+// isn't a terminal is read a line at a time.
+func ReadSecret(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := stdin.ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("failed to read secret: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	secret, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret: %w", err)
+	}
+
+	return string(secret), nil
+}
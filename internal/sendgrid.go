@@ -0,0 +1,159 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	netmail "net/mail"
+	"strconv"
+	"strings"
+)
+
+// Sends messages through the SendGrid v3 mail send API.
+type SendGridTransport struct {
+	API APIConfig
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridMail struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	ReplyTo          *sendGridAddress          `json:"reply_to,omitempty"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+	Attachments      []sendGridAttachment      `json:"attachments,omitempty"`
+	Headers          map[string]string         `json:"headers,omitempty"`
+}
+
+type sendGridPersonalization struct {
+	To  []sendGridAddress `json:"to"`
+	Cc  []sendGridAddress `json:"cc,omitempty"`
+	Bcc []sendGridAddress `json:"bcc,omitempty"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridAttachment struct {
+	Content     string `json:"content"`
+	Type        string `json:"type,omitempty"`
+	Filename    string `json:"filename"`
+	Disposition string `json:"disposition,omitempty"`
+	ContentID   string `json:"content_id,omitempty"`
+}
+
+func (t SendGridTransport) Send(ctx context.Context, raw RawMessage) (string, error) {
+	parsed, err := parseMessage(raw)
+	if err != nil {
+		return "", err
+	}
+
+	personalization := sendGridPersonalization{
+		To:  sendGridAddresses(parsed.To),
+		Cc:  sendGridAddresses(parsed.Cc),
+		Bcc: sendGridAddresses(parsed.Bcc),
+	}
+
+	body := sendGridMail{
+		Personalizations: []sendGridPersonalization{personalization},
+		From:             sendGridAddress{Email: parsed.From.Address, Name: parsed.From.Name},
+		Subject:          parsed.Subject,
+		Headers:          parsed.Headers,
+	}
+
+	if len(parsed.ReplyTo) > 0 {
+		body.ReplyTo = &sendGridAddress{Email: parsed.ReplyTo[0].Address, Name: parsed.ReplyTo[0].Name}
+	}
+
+	// SendGrid wants the plain text first
+	if parsed.Text != "" {
+		body.Content = append(body.Content, sendGridContent{Type: "text/plain", Value: parsed.Text})
+	}
+	if parsed.HTML != "" {
+		body.Content = append(body.Content, sendGridContent{Type: "text/html", Value: parsed.HTML})
+	}
+
+	for _, a := range parsed.Attachments {
+		attachment := sendGridAttachment{
+			Content:     base64.StdEncoding.EncodeToString(a.Data),
+			Type:        a.ContentType,
+			Filename:    a.Name,
+			Disposition: "attachment",
+		}
+		if a.ContentID != "" {
+			attachment.Disposition, attachment.ContentID = "inline", a.ContentID
+		}
+		body.Attachments = append(body.Attachments, attachment)
+	}
+
+	req, err := newJSONRequest(ctx, t.API.BaseURL+"/v3/mail/send", body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+t.API.Key)
+
+	status, header, data, err := doRequest(req)
+	if err != nil {
+		return "", err
+	}
+
+	if status >= 300 {
+		return "", sendGridError(status, data, personalization)
+	}
+
+	return fmt.Sprintf("%d %s, message id %s", status, http.StatusText(status), header.Get("X-Message-Id")), nil
+}
+
+func sendGridAddresses(addrs []*netmail.Address) []sendGridAddress {
+	list := []sendGridAddress{}
+	for _, addr := range addrs {
+		list = append(list, sendGridAddress{Email: addr.Address, Name: addr.Name})
+	}
+	return list
+}
+
+// Maps SendGrid's errors to SendErrors. Errors about a recipient point
+// at it with a field such as "personalizations.0.to.1.email".
+func sendGridError(status int, data []byte, personalization sendGridPersonalization) error {
+	var response struct {
+		Errors []struct {
+			Message string `json:"message"`
+			Field   string `json:"field"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil || len(response.Errors) == 0 {
+		return newSendError(TransportSendGrid, status, "", strings.TrimSpace(string(data)), statusKind(status), nil)
+	}
+
+	lists := map[string][]sendGridAddress{
+		"to":  personalization.To,
+		"cc":  personalization.Cc,
+		"bcc": personalization.Bcc,
+	}
+
+	messages, rejected := []string{}, []string{}
+	for _, e := range response.Errors {
+		messages = append(messages, e.Message)
+
+		parts := strings.Split(e.Field, ".")
+		if len(parts) < 4 || parts[0] != "personalizations" {
+			continue
+		}
+		i, err := strconv.Atoi(parts[3])
+		if list := lists[parts[2]]; err == nil && i >= 0 && i < len(list) {
+			rejected = append(rejected, list[i].Email)
+		}
+	}
+
+	return newSendError(TransportSendGrid, status, "", strings.Join(messages, "; "), statusKind(status), rejected)
+}
@@ -0,0 +1,160 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Sends messages through the Amazon SES v2 API, which takes the
+// rendered message as it is.
+type SESTransport struct {
+	API APIConfig
+}
+
+// SES errors, by the type in the x-amzn-ErrorType header, that don't
+// follow from their HTTP status.
+var sesErrorKinds = map[string]error{
+	"MessageRejected":                     ErrRejected,
+	"MailFromDomainNotVerifiedException":  ErrRejected,
+	"AccountSuspendedException":           ErrRejected,
+	"SendingPausedException":              ErrRejected,
+	"TooManyRequestsException":            ErrRateLimited,
+	"LimitExceededException":              ErrRateLimited,
+	"ThrottlingException":                 ErrRateLimited,
+	"UnrecognizedClientException":         ErrUnauthorized,
+	"InvalidSignatureException":           ErrUnauthorized,
+	"IncompleteSignatureException":        ErrUnauthorized,
+	"MissingAuthenticationTokenException": ErrUnauthorized,
+	"ExpiredTokenException":               ErrUnauthorized,
+	"AccessDeniedException":               ErrUnauthorized,
+}
+
+func (t SESTransport) Send(ctx context.Context, raw RawMessage) (string, error) {
+	body := map[string]any{
+		"FromEmailAddress": raw.From,
+		"Destination":      map[string]any{"ToAddresses": raw.Rcpts},
+		"Content":          map[string]any{"Raw": map[string]any{"Data": raw.Data}},
+	}
+
+	req, err := newJSONRequest(ctx, t.API.BaseURL+"/v2/email/outbound-emails", body)
+	if err != nil {
+		return "", err
+	}
+	if err := t.sign(req, time.Now()); err != nil {
+		return "", err
+	}
+
+	status, header, data, err := doRequest(req)
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		MessageID string `json:"MessageId"`
+		Message   string `json:"message"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		response.Message = strings.TrimSpace(string(data))
+	}
+
+	if status >= 300 {
+		// e.g. "MessageRejected:http://internal.amazon.com/coral/..."
+		code, _, _ := strings.Cut(header.Get("X-Amzn-Errortype"), ":")
+
+		kind, ok := sesErrorKinds[code]
+		if !ok {
+			kind = statusKind(status)
+		}
+
+		// Unverified recipients, in the sandbox, are named in the message
+		rejected := []string{}
+		if kind == ErrRejected {
+			rejected = recipientsIn(response.Message, raw.Rcpts)
+		}
+
+		return "", newSendError(TransportSES, status, code, response.Message, kind, rejected)
+	}
+
+	return fmt.Sprintf("%d %s, message id %s", status, http.StatusText(status), response.MessageID), nil
+}
+
+// Signs the request with AWS Signature Version 4.
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_sigv-create-signed-request.html
+func (t SESTransport) sign(req *http.Request, now time.Time) error {
+	payload, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(payload))
+
+	now = now.UTC()
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	if t.API.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", t.API.Token)
+	}
+
+	names := []string{"content-type", "host", "x-amz-date"}
+	if t.API.Token != "" {
+		names = append(names, "x-amz-security-token")
+	}
+
+	var headers strings.Builder
+	for _, name := range names {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		fmt.Fprintf(&headers, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		headers.String(),
+		signed,
+		hexSHA256(payload),
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/ses/aws4_request", date, t.API.Region)
+	toSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		req.Header.Get("X-Amz-Date"),
+		scope,
+		hexSHA256([]byte(canonical)),
+	}, "\n")
+
+	key := []byte("AWS4" + t.API.Secret)
+	for _, part := range []string{date, t.API.Region, "ses", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		t.API.Key, scope, signed, signature))
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,178 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Config files looked for when no file is given.
+var configFiles = []string{"config.yaml", "config.yml", "config.toml"}
+
+// Keys of the config file that are named differently from their
+// environment variable.
+var settingAliases = map[string]string{
+	"CC":  "CC_EMAIL",
+	"BCC": "BCC_EMAIL",
+}
+
+// Merges every source of settings into the environment, where
+// LoadConfig and the rest of the program read them. From highest to
+// lowest precedence:
+//
+//  1. flags, e.g. -sender-name
+//  2. real environment variables
+//  3. the .env file
+//  4. the chosen profile of the config file
+//  5. the rest of the config file
+//
+// The config file is file, CONFIG_FILE or the first of config.yaml,
+// config.yml and config.toml that exists, if any. The profile is
+// profile, PROFILE or the file's "profile" setting.
+func LoadSettings(file, profile string, flags map[string]string) error {
+	for key, value := range flags {
+		os.Setenv(key, value)
+	}
+
+	// A missing .env file is fine, the settings may come from elsewhere
+	_ = godotenv.Load()
+
+	if file == "" {
+		file = os.Getenv("CONFIG_FILE")
+	}
+	if file == "" {
+		for _, name := range configFiles {
+			if _, err := os.Stat(name); err == nil {
+				file = name
+				break
+			}
+		}
+	}
+
+	base, profiles := map[string]string{}, map[string]map[string]string{}
+	if file != "" {
+		var err error
+		base, profiles, err = readConfigFile(file)
+		if err != nil {
+			return err
+		}
+	}
+
+	if profile == "" {
+		profile = getenv("PROFILE", base["PROFILE"])
+	}
+
+	if profile != "" {
+		values, ok := profiles[profile]
+		if !ok {
+			names := []string{}
+			for name := range profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown profile %q, the profiles in %q are: %s", profile, file, strings.Join(names, ", "))
+		}
+		setDefaults(values)
+		os.Setenv("PROFILE", profile)
+	}
+	setDefaults(base)
+
+	return nil
+}
+
+// Sets the environment variables that aren't set yet.
+func setDefaults(values map[string]string) {
+	for key, value := range values {
+		if _, ok := os.LookupEnv(key); !ok {
+			os.Setenv(key, value)
+		}
+	}
+}
+
+// Reads a YAML or TOML config file into its top-level settings and
+// the settings of each profile under "profiles".
+func readConfigFile(path string) (map[string]string, map[string]map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("config file %s not found", path)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	raw := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, nil, fmt.Errorf("config file %s must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	profiles := map[string]map[string]string{}
+	if section, ok := raw["profiles"]; ok {
+		sections, ok := section.(map[string]any)
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid config file %s: profiles must be a table", path)
+		}
+		for name, section := range sections {
+			values, ok := section.(map[string]any)
+			if !ok {
+				return nil, nil, fmt.Errorf("invalid config file %s: profile %s must be a table", path, name)
+			}
+			profiles[name] = flattenSettings("", values)
+		}
+		delete(raw, "profiles")
+	}
+
+	return flattenSettings("", raw), profiles, nil
+}
+
+// Turns nested settings into environment variable names, e.g.
+// smtp: {host: ...} into SMTP_HOST. Lists are joined with commas.
+func flattenSettings(prefix string, values map[string]any) map[string]string {
+	settings := map[string]string{}
+
+	for key, value := range values {
+		key = strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+
+		switch v := value.(type) {
+		case map[string]any:
+			for k, v := range flattenSettings(key, v) {
+				settings[k] = v
+			}
+			continue
+		case []any:
+			list := make([]string, len(v))
+			for i, item := range v {
+				list[i] = fmt.Sprint(item)
+			}
+			settings[key] = strings.Join(list, ", ")
+		default:
+			settings[key] = fmt.Sprint(v)
+		}
+
+		if alias, ok := settingAliases[key]; ok {
+			settings[alias] = settings[key]
+			delete(settings, key)
+		}
+	}
+
+	return settings
+}
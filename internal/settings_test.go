@@ -0,0 +1,39 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSettingsConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, sender := range map[string]string{"flag.yaml": "From Flag", "env.yaml": "From Env"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("sender_name: "+sender+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, test := range map[string]struct {
+		file string
+		want string
+	}{
+		"-config over CONFIG_FILE": {filepath.Join(dir, "flag.yaml"), "From Flag"},
+		"CONFIG_FILE":              {"", "From Env"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("CONFIG_FILE", filepath.Join(dir, "env.yaml"))
+			t.Setenv("SENDER_NAME", "")
+			os.Unsetenv("SENDER_NAME")
+
+			if err := LoadSettings(test.file, "", nil); err != nil {
+				t.Fatal(err)
+			}
+			if got := os.Getenv("SENDER_NAME"); got != test.want {
+				t.Errorf("SENDER_NAME = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
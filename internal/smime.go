@@ -0,0 +1,313 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mozilla.org/pkcs7"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// What S/MIME does with outgoing messages, set with SMIME_MODE.
+const (
+	SMIMESign    = "sign"    // Sign every message
+	SMIMEEncrypt = "encrypt" // Also encrypt when every recipient has a certificate
+)
+
+// Headers that describe the MIME entity itself rather than the message,
+// and so move inside the signed part.
+var entityHeaders = []string{"Content-Type", "Content-Transfer-Encoding", "Content-Disposition", "Content-Id", "Content-Description"}
+
+// Signs messages with S/MIME, so mail clients show that they really
+// come from the sender. In SMIMEEncrypt mode, messages are also
+// encrypted if a certificate is found for each recipient and none are
+// blind copied, and only signed otherwise.
+type SMIMESigner struct {
+	Mode  string
+	Key   crypto.PrivateKey
+	Cert  *x509.Certificate
+	Chain []*x509.Certificate // Intermediate certificates sent along
+
+	// A folder of recipients' certificates, each named after the
+	// address, e.g. jane@example.com.pem
+	CertsDir string
+}
+
+func init() {
+	// The default, DES, isn't accepted by current mail clients
+	pkcs7.ContentEncryptionAlgorithm = pkcs7.EncryptionAlgorithmAES256CBC
+}
+
+// Loads the PKCS#12 certificate in SMIME_CERT, unlocked with
+// SMIME_PASSWORD. Returns warnings about a certificate that works but
+// should be replaced.
+func loadSMIMEConfig(from User, secrets SecretStore) (*SMIMESigner, []string, error) {
+	path := os.Getenv("SMIME_CERT")
+	if path == "" {
+		return nil, nil, nil
+	}
+
+	signer := &SMIMESigner{
+		Mode:     strings.ToLower(getenv("SMIME_MODE", SMIMESign)),
+		CertsDir: getenv("SMIME_RECIPIENT_CERTS", "certs"),
+	}
+	if signer.Mode != SMIMESign && signer.Mode != SMIMEEncrypt {
+		return nil, nil, fmt.Errorf("SMIME_MODE must be %s or %s", SMIMESign, SMIMEEncrypt)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid SMIME_CERT: %w", err)
+	}
+
+	password, err := secrets.Get("SMIME_PASSWORD")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, cert, chain, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid SMIME_CERT or SMIME_PASSWORD: %w", err)
+	}
+
+	switch key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+	default:
+		return nil, nil, fmt.Errorf("SMIME_CERT must hold an RSA or ECDSA key")
+	}
+
+	// Messages are encrypted to the sender too, and only to RSA keys
+	if _, ok := cert.PublicKey.(*rsa.PublicKey); signer.Mode == SMIMEEncrypt && !ok {
+		return nil, nil, fmt.Errorf("SMIME_MODE=%s needs an RSA SMIME_CERT", SMIMEEncrypt)
+	}
+
+	if time.Now().After(cert.NotAfter) {
+		return nil, nil, fmt.Errorf("the SMIME_CERT certificate expired on %s", cert.NotAfter.Format(time.DateOnly))
+	}
+
+	signer.Key, signer.Cert, signer.Chain = key, cert, chain
+
+	warnings := []string{}
+	if time.Until(cert.NotAfter) < 30*24*time.Hour {
+		warnings = append(warnings, fmt.Sprintf("the SMIME_CERT certificate expires on %s", cert.NotAfter.Format(time.DateOnly)))
+	}
+	if !slices.ContainsFunc(cert.EmailAddresses, func(address string) bool { return strings.EqualFold(address, from.Email) }) {
+		warnings = append(warnings, fmt.Sprintf("the SMIME_CERT certificate isn't for %s, so mail clients will flag the signature", from.Email))
+	}
+
+	return signer, warnings, nil
+}
+
+func (s *SMIMESigner) Sign(raw RawMessage) ([]byte, error) {
+	header, entity, err := splitEntity(crlf(raw.Data))
+	if err != nil {
+		return nil, err
+	}
+
+	signed, err := s.sign(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Mode == SMIMEEncrypt {
+		// Every recipient gets the same certificates in the encrypted
+		// message, which would show who was blind copied
+		if bcc := blindCopied(header, raw.Rcpts); len(bcc) > 0 {
+			logger.Info("smime sign only", "message_id", raw.MessageID, "bcc", strings.Join(bcc, ", "))
+			return append(header, signed...), nil
+		}
+
+		recipients, missing := s.recipientCerts(raw.Rcpts)
+		if len(missing) == 0 {
+			encrypted, err := pkcs7.Encrypt(signed, append(recipients, s.Cert))
+			if err != nil {
+				return nil, fmt.Errorf("failed to encrypt with S/MIME: %w", err)
+			}
+
+			return slices.Concat(header, []byte(
+				"Content-Type: application/pkcs7-mime; smime-type=enveloped-data; name=\"smime.p7m\"\r\n"+
+					"Content-Transfer-Encoding: base64\r\n"+
+					"Content-Disposition: attachment; filename=\"smime.p7m\"\r\n\r\n"),
+				base64Lines(encrypted)), nil
+		}
+
+		logger.Info("smime sign only", "message_id", raw.MessageID, "missing_certificates", strings.Join(missing, ", "))
+	}
+
+	return append(header, signed...), nil
+}
+
+// Returns the entity as a multipart/signed entity, with a detached
+// signature.
+func (s *SMIMESigner) sign(entity []byte) ([]byte, error) {
+	signedData, err := pkcs7.NewSignedData(entity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign with S/MIME: %w", err)
+	}
+	signedData.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+
+	if err := signedData.AddSignerChain(s.Cert, s.Key, s.Chain, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, fmt.Errorf("failed to sign with S/MIME: %w", err)
+	}
+	signedData.Detach()
+
+	signature, err := signedData.Finish()
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign with S/MIME: %w", err)
+	}
+
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	boundary := "signed-" + hex.EncodeToString(b)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; micalg=sha-256;\r\n boundary=\"%s\"\r\n\r\n", boundary)
+	buf.WriteString("This is a cryptographically signed message in MIME format.\r\n\r\n")
+	fmt.Fprintf(&buf, "--%s\r\n", boundary)
+	buf.Write(entity)
+	fmt.Fprintf(&buf, "\r\n--%s\r\n", boundary)
+	buf.WriteString("Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Disposition: attachment; filename=\"smime.p7s\"\r\n\r\n")
+	buf.Write(base64Lines(signature))
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+
+	return buf.Bytes(), nil
+}
+
+// Returns the certificates of the recipients, and the recipients that
+// don't have one.
+func (s *SMIMESigner) recipientCerts(rcpts []string) ([]*x509.Certificate, []string) {
+	certs, missing := []*x509.Certificate{}, []string{}
+
+	for _, rcpt := range rcpts {
+		cert, err := s.recipientCert(rcpt)
+		if err != nil {
+			missing = append(missing, rcpt)
+			continue
+		}
+		certs = append(certs, cert)
+	}
+
+	return certs, missing
+}
+
+// Reads the recipient's certificate, in PEM or DER, from the folder.
+// Certificates without an RSA key can't be encrypted to, so they're
+// treated as missing.
+func (s *SMIMESigner) recipientCert(rcpt string) (*x509.Certificate, error) {
+	for _, ext := range []string{".pem", ".crt", ".cer"} {
+		data, err := os.ReadFile(filepath.Join(s.CertsDir, strings.ToLower(rcpt)+ext))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if block, _ := pem.Decode(data); block != nil {
+			data = block.Bytes
+		}
+
+		cert, err := x509.ParseCertificate(data)
+		if err != nil {
+			return nil, err
+		}
+		if time.Now().After(cert.NotAfter) {
+			return nil, fmt.Errorf("the certificate of %s has expired", rcpt)
+		}
+		if _, ok := cert.PublicKey.(*rsa.PublicKey); !ok {
+			return nil, fmt.Errorf("the certificate of %s doesn't have an RSA key", rcpt)
+		}
+		return cert, nil
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// Returns the recipients that aren't in the To or Cc header, that is,
+// who were blind copied. Addresses in a header that can't be parsed
+// count as blind copied.
+func blindCopied(header []byte, rcpts []string) []string {
+	shown := map[string]bool{}
+	for _, field := range splitHeader(string(header)) {
+		name, value, _ := strings.Cut(field, ":")
+		name = strings.TrimSpace(name)
+		if !strings.EqualFold(name, "To") && !strings.EqualFold(name, "Cc") {
+			continue
+		}
+
+		addresses, err := mail.ParseAddressList(strings.ReplaceAll(value, "\r\n", ""))
+		if err != nil {
+			continue
+		}
+		for _, address := range addresses {
+			shown[strings.ToLower(address.Address)] = true
+		}
+	}
+
+	bcc := []string{}
+	for _, rcpt := range rcpts {
+		if !shown[strings.ToLower(rcpt)] {
+			bcc = append(bcc, rcpt)
+		}
+	}
+	return bcc
+}
+
+// Splits a message into the headers of the message itself, which stay
+// outside, and its MIME entity: the Content-* headers and the body.
+func splitEntity(data []byte) ([]byte, []byte, error) {
+	header, body, ok := bytes.Cut(data, []byte("\r\n\r\n"))
+	if !ok {
+		return nil, nil, errors.New("message has no body")
+	}
+
+	var outer, entity bytes.Buffer
+	for _, field := range splitHeader(string(header) + "\r\n") {
+		name, _, _ := strings.Cut(field, ":")
+		if slices.ContainsFunc(entityHeaders, func(h string) bool { return strings.EqualFold(h, strings.TrimSpace(name)) }) {
+			entity.WriteString(field + "\r\n")
+		} else {
+			outer.WriteString(field + "\r\n")
+		}
+	}
+
+	entity.WriteString("\r\n")
+	entity.Write(body)
+
+	return outer.Bytes(), entity.Bytes(), nil
+}
+
+// Encodes the data as base64 in lines of 76 characters.
+func base64Lines(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	var buf bytes.Buffer
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+
+	return buf.Bytes()
+}
@@ -0,0 +1,285 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mozilla.org/pkcs7"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const testMessage = "From: Me <me@example.com>\r\n" +
+	"To: jane@example.com\r\n" +
+	"Subject: Hello\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: 7bit\r\n" +
+	"\r\n" +
+	"Hello, Jane.\r\n"
+
+// Returns a self-signed certificate for the address, with its key.
+func testCert(t *testing.T, key crypto.Signer, address string) *x509.Certificate {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(time.Now().UnixNano()),
+		Subject:        pkix.Name{CommonName: address},
+		EmailAddresses: []string{address},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func testECDSAKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// Writes the certificate to the folder as the address's PEM file.
+func writeRecipientCert(t *testing.T, dir, address string, cert *x509.Certificate) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if err := os.WriteFile(filepath.Join(dir, address+".pem"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Checks the detached signature of a multipart/signed entity, and
+// returns the entity that was signed.
+func verifySigned(t *testing.T, entity []byte) []byte {
+	t.Helper()
+
+	header, _, _ := bytes.Cut(entity, []byte("\r\n\r\n"))
+	contentType := ""
+	for _, field := range splitHeader(string(header) + "\r\n") {
+		if name, value, _ := strings.Cut(field, ":"); strings.EqualFold(name, "Content-Type") {
+			contentType = value
+		}
+	}
+	mediaType, params, err := mime.ParseMediaType(strings.Join(strings.Fields(contentType), " "))
+	if err != nil || mediaType != "multipart/signed" {
+		t.Fatalf("entity isn't multipart/signed: %q", contentType)
+	}
+	boundary := []byte("--" + params["boundary"])
+
+	parts := bytes.Split(entity, boundary)
+	if len(parts) != 4 {
+		t.Fatalf("multipart/signed has %d parts, want 2", len(parts)-2)
+	}
+	signed := bytes.TrimSuffix(bytes.TrimPrefix(parts[1], []byte("\r\n")), []byte("\r\n"))
+
+	_, signature, _ := bytes.Cut(parts[2], []byte("\r\n\r\n"))
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(signature)), ""))
+	if err != nil {
+		t.Fatalf("invalid signature encoding: %v", err)
+	}
+
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		t.Fatalf("invalid signature: %v", err)
+	}
+	p7.Content = signed
+	if err := p7.Verify(); err != nil {
+		t.Fatalf("signature doesn't verify: %v", err)
+	}
+
+	return signed
+}
+
+// Returns the outer headers of a signed or encrypted message and its
+// entity.
+func cutMessage(t *testing.T, data []byte) (string, []byte) {
+	t.Helper()
+	i := bytes.Index(data, []byte("Content-Type:"))
+	if i < 0 {
+		t.Fatalf("message has no Content-Type:\n%s", data)
+	}
+	return string(data[:i]), data[i:]
+}
+
+func TestSMIMESign(t *testing.T) {
+	for name, key := range map[string]crypto.Signer{"rsa": testRSAKey(t), "ecdsa": testECDSAKey(t)} {
+		t.Run(name, func(t *testing.T) {
+			signer := &SMIMESigner{Mode: SMIMESign, Key: key, Cert: testCert(t, key, "me@example.com")}
+
+			data, err := signer.Sign(RawMessage{Rcpts: []string{"jane@example.com"}, Data: []byte(testMessage)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			outer, entity := cutMessage(t, data)
+			if !strings.Contains(outer, "Subject: Hello") {
+				t.Errorf("the Subject header isn't kept outside the signed part:\n%s", outer)
+			}
+
+			signed := verifySigned(t, entity)
+			if !bytes.Contains(signed, []byte("Content-Type: text/plain")) || !bytes.HasSuffix(signed, []byte("Hello, Jane.\r\n")) {
+				t.Errorf("the signed part isn't the message's entity:\n%s", signed)
+			}
+		})
+	}
+}
+
+func TestSMIMEEncrypt(t *testing.T) {
+	senderKey := testRSAKey(t)
+	senderCert := testCert(t, senderKey, "me@example.com")
+	janeKey := testRSAKey(t)
+	janeCert := testCert(t, janeKey, "jane@example.com")
+
+	dir := t.TempDir()
+	writeRecipientCert(t, dir, "jane@example.com", janeCert)
+
+	signer := &SMIMESigner{Mode: SMIMEEncrypt, Key: senderKey, Cert: senderCert, CertsDir: dir}
+	data, err := signer.Sign(RawMessage{Rcpts: []string{"Jane@example.com"}, Data: []byte(testMessage)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, entity := cutMessage(t, data)
+	if !bytes.HasPrefix(entity, []byte("Content-Type: application/pkcs7-mime; smime-type=enveloped-data")) {
+		t.Fatalf("message isn't encrypted:\n%s", entity)
+	}
+
+	_, body, _ := bytes.Cut(entity, []byte("\r\n\r\n"))
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both the recipient and the sender can read it
+	for _, reader := range []struct {
+		cert *x509.Certificate
+		key  crypto.PrivateKey
+	}{{janeCert, janeKey}, {senderCert, senderKey}} {
+		p7, err := pkcs7.Parse(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := p7.Decrypt(reader.cert, reader.key)
+		if err != nil {
+			t.Fatalf("%s can't decrypt: %v", reader.cert.EmailAddresses[0], err)
+		}
+		verifySigned(t, decrypted)
+	}
+}
+
+func TestSMIMEEncryptFallsBackToSigning(t *testing.T) {
+	senderKey := testRSAKey(t)
+	dir := t.TempDir()
+
+	// An ECDSA certificate can't be encrypted to
+	writeRecipientCert(t, dir, "jane@example.com", testCert(t, testECDSAKey(t), "jane@example.com"))
+
+	signer := &SMIMESigner{Mode: SMIMEEncrypt, Key: senderKey, Cert: testCert(t, senderKey, "me@example.com"), CertsDir: dir}
+	for _, rcpts := range [][]string{{"jane@example.com"}, {"nobody@example.com"}} {
+		data, err := signer.Sign(RawMessage{Rcpts: rcpts, Data: []byte(testMessage)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, entity := cutMessage(t, data)
+		verifySigned(t, entity)
+	}
+}
+
+func TestSMIMEEncryptSkipsBlindCopies(t *testing.T) {
+	senderKey := testRSAKey(t)
+	dir := t.TempDir()
+	for _, address := range []string{"jane@example.com", "boss@example.com", "audit@example.com"} {
+		writeRecipientCert(t, dir, address, testCert(t, testRSAKey(t), address))
+	}
+
+	message := strings.Replace(testMessage, "To: jane@example.com\r\n",
+		"To: \"Doe, Jane\" <jane@example.com>\r\nCc: Her Boss\r\n <Boss@example.com>\r\n", 1)
+	signer := &SMIMESigner{Mode: SMIMEEncrypt, Key: senderKey, Cert: testCert(t, senderKey, "me@example.com"), CertsDir: dir}
+
+	for _, test := range []struct {
+		rcpts   []string
+		encrypt bool
+	}{
+		{[]string{"jane@example.com", "boss@example.com"}, true},
+		// Encrypting to audit's certificate would show everyone that
+		// they were blind copied
+		{[]string{"jane@example.com", "boss@example.com", "audit@example.com"}, false},
+	} {
+		data, err := signer.Sign(RawMessage{Rcpts: test.rcpts, Data: []byte(message)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, entity := cutMessage(t, data)
+		encrypted := bytes.HasPrefix(entity, []byte("Content-Type: application/pkcs7-mime"))
+		if encrypted != test.encrypt {
+			t.Errorf("%v: encrypted = %t, want %t", test.rcpts, encrypted, test.encrypt)
+		}
+		if !encrypted {
+			verifySigned(t, entity)
+		}
+	}
+}
+
+func TestLoadSMIMEConfigEncryptNeedsRSA(t *testing.T) {
+	key := testECDSAKey(t)
+	pfx, err := pkcs12.Modern.Encode(key, testCert(t, key, "me@example.com"), nil, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "me.p12")
+	if err := os.WriteFile(path, pfx, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("SMIME_CERT", path)
+	t.Setenv("SMIME_PASSWORD", "secret")
+	from := User{Email: "me@example.com"}
+
+	t.Setenv("SMIME_MODE", SMIMESign)
+	if _, _, err := loadSMIMEConfig(from, envStore{}); err != nil {
+		t.Errorf("an ECDSA certificate should sign: %v", err)
+	}
+
+	t.Setenv("SMIME_MODE", SMIMEEncrypt)
+	if _, _, err := loadSMIMEConfig(from, envStore{}); err == nil {
+		t.Error("an ECDSA certificate was accepted for encryption")
+	}
+}
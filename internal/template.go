@@ -0,0 +1,147 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/wneessen/go-mail"
+)
+
+type Template struct {
+	Name            string            // Short name, e.g. CRED
+	Subject         string            // Subject line, a text/template with a TemplateContext
+	Importance      string            // low, high, urgent or non-urgent, normal if unset
+	ReplyTo         string            // Address replies go to, e.g. "HR <hr@example.com>"
+	ListUnsubscribe string            // Value of the List-Unsubscribe header, e.g. "<mailto:...>"
+	Headers         map[string]string // Any other headers to set
+	Body            string            // HTML body, an html/template with a TemplateContext
+	Content         string            // Or the content of the body alone, put in the shared layout
+	Markdown        bool              // Content is Markdown rather than HTML
+	Images          []InlineImage     // Images embedded in the body
+	Attachments     []string          // Paths of files attached to every email
+	SecretPassword  bool              // The password is put behind a one-time link, if those are set up
+
+	// The subject and body in other locales than DefaultLocale, e.g.
+	// "fil" or "zh". Recipients in a locale without one get the
+	// template in DEFAULT_LOCALE, or else as it is.
+	Translations map[string]Translation
+}
+
+// A template's subject and body in another locale. Fields left empty
+// are the template's own.
+type Translation struct {
+	Subject string
+	Body    string
+	Content string
+}
+
+// Returns the template in the locale, and whether it's written in it.
+func (t Template) Localize(locale string) (Template, bool) {
+	if locale == DefaultLocale {
+		return t, true
+	}
+
+	tr, ok := t.Translations[locale]
+	if !ok {
+		return t, false
+	}
+	t.Subject = cmp.Or(tr.Subject, t.Subject)
+	t.Body = cmp.Or(tr.Body, t.Body)
+	t.Content = cmp.Or(tr.Content, t.Content)
+	return t, true
+}
+
+// An image the body refers to as cid:CID, embedded in the email so it
+// shows even in mail clients that block remote images. It's read from
+// Path in FS, such as the files built into the program, or on the disk
+// if FS is nil.
+type InlineImage struct {
+	CID  string
+	Path string
+	FS   fs.FS
+}
+
+// Reads the image file.
+func (i InlineImage) Read() ([]byte, error) {
+	if i.FS != nil {
+		return fs.ReadFile(i.FS, i.Path)
+	}
+	return os.ReadFile(i.Path)
+}
+
+// The company logo shown at the top of every template, built into the
+// program.
+var logo = InlineImage{
+	CID:  "logo",
+	Path: "templates/logo.png",
+	FS:   templateFiles,
+}
+
+var (
+	Credentials = Template{
+		Name:           "CRED",
+		Subject:        "OfficeTimer Credentials for the Internship in Knowles Training Institute",
+		Content:        templateFile("credentials.en.html"),
+		Images:         []InlineImage{logo},
+		SecretPassword: true,
+		Translations: map[string]Translation{
+			"fil": {
+				Subject: "Ang Iyong OfficeTimer Credentials para sa Internship sa Knowles Training Institute",
+				Content: templateFile("credentials.fil.html"),
+			},
+			"zh": {
+				Subject: "Knowles Training Institute 实习 OfficeTimer 账户信息",
+				Content: templateFile("credentials.zh.html"),
+			},
+		},
+	}
+
+	Late = Template{
+		Name:       "LATE",
+		Subject:    "Important Reminder for Late Interns",
+		Importance: "high",
+		Content:    templateFile("late.en.md"),
+		Markdown:   true,
+		Images:     []InlineImage{logo},
+		Translations: map[string]Translation{
+			"fil": {
+				Subject: "Mahalagang Paalala para sa mga Intern na Nahuhuli",
+				Content: templateFile("late.fil.md"),
+			},
+			"zh": {
+				Subject: "致迟到实习生的重要提醒",
+				Content: templateFile("late.zh.md"),
+			},
+		},
+	}
+)
+
+// Importance flags a template can set.
+var importances = map[string]mail.Importance{
+	"low":        mail.ImportanceLow,
+	"normal":     mail.ImportanceNormal,
+	"high":       mail.ImportanceHigh,
+	"urgent":     mail.ImportanceUrgent,
+	"non-urgent": mail.ImportanceNonUrgent,
+}
+
+// Returns every template, enabled or not.
+func AllTemplates() []Template {
+	return []Template{Credentials, Late}
+}
+
+// Returns the template with the given short name (e.g. CRED, LATE).
+func LookupTemplate(name string) (Template, error) {
+	switch strings.ToUpper(name) {
+	case "CRED":
+		return Credentials, nil
+	case "LATE":
+		return Late, nil
+	}
+	return Template{}, fmt.Errorf("unknown template %q", name)
+}
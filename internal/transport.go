@@ -0,0 +1,352 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wneessen/go-mail"
+)
+
+// Transports that emails can be sent through, set with TRANSPORT.
+const (
+	TransportSMTP     = "smtp"     // The configured SMTP server
+	TransportSendmail = "sendmail" // The local sendmail binary
+	TransportFile     = "file"     // .eml files in a folder
+	TransportMaildir  = "maildir"  // A Maildir folder
+	TransportMemory   = "memory"   // Kept in memory, for tests
+	TransportSendGrid = "sendgrid" // The SendGrid v3 API
+	TransportMailgun  = "mailgun"  // The Mailgun API
+	TransportSES      = "ses"      // The Amazon SES v2 API
+	TransportPostmark = "postmark" // The Postmark API
+)
+
+// Delivers rendered messages.
+type Transport interface {
+	// Sends the message and returns the server's reply to it.
+	Send(ctx context.Context, message RawMessage) (string, error)
+}
+
+// An already rendered message and its envelope.
+type RawMessage struct {
+	Template  string
+	MessageID string
+	From      string
+	Rcpts     []string
+	Data      []byte
+}
+
+// Returns the transport selected in the config, which signs messages
+// first if signing is set up.
+func NewTransport(config EmailConfig) (Transport, error) {
+	transport, err := newTransport(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// DKIM goes last, since it signs the message as it's sent
+	signers := []Signer{}
+	if config.SMIME != nil {
+		signers = append(signers, config.SMIME)
+	}
+	if config.DKIM != nil {
+		signers = append(signers, config.DKIM)
+	}
+
+	if len(signers) == 0 {
+		return transport, nil
+	}
+	return SigningTransport{Transport: transport, Signers: signers}, nil
+}
+
+func newTransport(config EmailConfig) (Transport, error) {
+	switch config.Transport {
+	case TransportSMTP, "":
+		return SMTPTransport{Config: config}, nil
+	case TransportSendmail:
+		return SendmailTransport{Path: config.SendmailPath}, nil
+	case TransportFile:
+		return FileTransport{Dir: config.MailDir}, nil
+	case TransportMaildir:
+		return FileTransport{Dir: config.MailDir, Maildir: true}, nil
+	case TransportMemory:
+		return &MemoryTransport{}, nil
+	case TransportSendGrid:
+		return SendGridTransport{API: config.API}, nil
+	case TransportMailgun:
+		return MailgunTransport{API: config.API}, nil
+	case TransportSES:
+		return SESTransport{API: config.API}, nil
+	case TransportPostmark:
+		return PostmarkTransport{API: config.API}, nil
+	}
+	return nil, fmt.Errorf("unknown TRANSPORT %q", config.Transport)
+}
+
+// Changes a rendered message before it's sent, e.g. to sign it.
+type Signer interface {
+	// Returns the new message data.
+	Sign(raw RawMessage) ([]byte, error)
+}
+
+// Passes messages through each of the signers in order, then sends them
+// through the transport. Messages are signed when they're sent, so queued
+// ones are signed with the current keys.
+type SigningTransport struct {
+	Transport Transport
+	Signers   []Signer
+}
+
+func (t SigningTransport) Send(ctx context.Context, raw RawMessage) (string, error) {
+	for _, signer := range t.Signers {
+		data, err := signer.Sign(raw)
+		if err != nil {
+			return "", err
+		}
+		raw.Data = data
+	}
+	return t.Transport.Send(ctx, raw)
+}
+
+// Renders the message into the exact bytes that are sent.
+func render(template string, message *mail.Msg) (RawMessage, error) {
+	from, err := message.GetSender(false)
+	if err != nil {
+		return RawMessage{}, err
+	}
+
+	rcpts, err := message.GetRecipients()
+	if err != nil {
+		return RawMessage{}, err
+	}
+
+	var buf bytes.Buffer
+	if _, err := message.WriteTo(&buf); err != nil {
+		return RawMessage{}, err
+	}
+
+	return RawMessage{
+		Template:  template,
+		MessageID: message.GetMessageID(),
+		From:      from,
+		Rcpts:     rcpts,
+		Data:      buf.Bytes(),
+	}, nil
+}
+
+// Sends the message through the transport. Every attempt is logged.
+func deliverRaw(ctx context.Context, transport Transport, raw RawMessage) (response string, err error) {
+	start := time.Now()
+	defer func() {
+		logAttempt(transport, raw, response, start, err)
+	}()
+
+	return transport.Send(ctx, raw)
+}
+
+// Sends messages through an SMTP server.
+type SMTPTransport struct {
+	Config EmailConfig
+}
+
+func (t SMTPTransport) Send(ctx context.Context, raw RawMessage) (string, error) {
+	client, err := newClient(ctx, t.Config)
+	if err != nil {
+		return "", err
+	}
+
+	smtpClient, err := client.DialToSMTPClientWithContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer client.CloseWithSMTPClient(smtpClient)
+
+	if err := smtpClient.Mail(raw.From); err != nil {
+		return "", err
+	}
+
+	for _, rcpt := range raw.Rcpts {
+		if err := smtpClient.Rcpt(rcpt); err != nil {
+			return "", err
+		}
+	}
+
+	// DATA is sent by hand, since the client's Data writer
+	// doesn't give back the server's reply to the message.
+	if _, _, err := cmd(smtpClient.Text, 354, "DATA"); err != nil {
+		return "", err
+	}
+
+	writer := smtpClient.Text.DotWriter()
+	if _, err := writer.Write(raw.Data); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	code, msg, err := smtpClient.Text.ReadResponse(250)
+	return fmt.Sprintf("%d %s", code, msg), err
+}
+
+func cmd(conn *textproto.Conn, expectCode int, command string) (int, string, error) {
+	id, err := conn.Cmd("%s", command)
+	if err != nil {
+		return 0, "", err
+	}
+	conn.StartResponse(id)
+	defer conn.EndResponse(id)
+	return conn.ReadResponse(expectCode)
+}
+
+// SMTP server configuration
+func newClient(ctx context.Context, config EmailConfig) (*mail.Client, error) {
+	// A server on this PC, such as the devserver, needn't offer STARTTLS
+	tlsPolicy := mail.TLSMandatory
+	if IsLoopback(config.SMTPHost) {
+		tlsPolicy = mail.TLSOpportunistic
+	}
+
+	if config.Auth == AuthXOAUTH2 {
+		token, err := config.OAuth.AccessToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return mail.NewClient(config.SMTPHost,
+			mail.WithPort(config.SMTPPort),
+			mail.WithTLSPolicy(tlsPolicy),
+			mail.WithSMTPAuth(mail.SMTPAuthXOAUTH2),
+			mail.WithUsername(config.SMTPUser),
+			mail.WithPassword(token))
+	}
+
+	return mail.NewClient(config.SMTPHost,
+		mail.WithPort(config.SMTPPort),
+		mail.WithTLSPolicy(tlsPolicy),
+		mail.WithSMTPAuth(mail.SMTPAuthPlain),
+		mail.WithUsername(config.SMTPUser),
+		mail.WithPassword(config.SMTPPass))
+}
+
+// Reports whether the host is this PC.
+func IsLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Pipes messages to a local sendmail binary, e.g. Postfix's or msmtp.
+type SendmailTransport struct {
+	Path string
+}
+
+func (t SendmailTransport) Send(ctx context.Context, raw RawMessage) (string, error) {
+	// -i keeps a line with a single dot from ending the message
+	args := append([]string{"-i", "-f", raw.From, "--"}, raw.Rcpts...)
+	command := exec.CommandContext(ctx, t.Path, args...)
+	command.Stdin = bytes.NewReader(raw.Data)
+
+	output, err := command.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return "", fmt.Errorf("%s: %w: %s", t.Path, err, msg)
+		}
+		return "", fmt.Errorf("%s: %w", t.Path, err)
+	}
+
+	return "accepted by " + t.Path, nil
+}
+
+// Saves messages as .eml files in a folder instead of sending them, or
+// in a Maildir that a mail client can open.
+type FileTransport struct {
+	Dir     string
+	Maildir bool
+}
+
+func (t FileTransport) Send(ctx context.Context, raw RawMessage) (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%d.%s", time.Now().UnixNano(), hex.EncodeToString(b))
+
+	if !t.Maildir {
+		path := filepath.Join(t.Dir, name+".eml")
+		if err := writeFile(path, raw.Data); err != nil {
+			return "", err
+		}
+		return "saved to " + path, nil
+	}
+
+	// Maildir messages are written to tmp and moved to new once complete
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(t.Dir, sub), 0o700); err != nil {
+			return "", err
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	name += "." + strings.NewReplacer("/", `\057`, ":", `\072`).Replace(hostname)
+
+	tmp := filepath.Join(t.Dir, "tmp", name)
+	if err := os.WriteFile(tmp, raw.Data, 0o600); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(t.Dir, "new", name)
+	if err := os.Rename(tmp, path); err != nil {
+		return "", err
+	}
+
+	return "saved to " + path, nil
+}
+
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// Keeps every message it's given, for tests. If Err is set, every send
+// fails with it instead.
+type MemoryTransport struct {
+	mu       sync.Mutex
+	messages []RawMessage
+	Err      error
+}
+
+func (t *MemoryTransport) Send(ctx context.Context, raw RawMessage) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.Err != nil {
+		return "", t.Err
+	}
+
+	t.messages = append(t.messages, raw)
+	return fmt.Sprintf("recorded message %d", len(t.messages)), nil
+}
+
+// Returns the messages sent so far.
+func (t *MemoryTransport) Messages() []RawMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]RawMessage{}, t.messages...)
+}
@@ -0,0 +1,190 @@
+// Copyright © 2025 Duane Matthew P. Chan
+
+package email
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Returns the config of a sender with a CC and BCC on every email.
+func testConfig() EmailConfig {
+	return EmailConfig{
+		From: User{Name: "Monitoring", Email: "monitoring@example.com"},
+		CC:   []User{{Email: "hr@example.com"}},
+		BCC:  []User{{Email: "records@example.com"}},
+	}
+}
+
+func TestSendThroughMemoryTransport(t *testing.T) {
+	transport := &MemoryTransport{}
+	em := Email{
+		Body: Late,
+		To: User{
+			Name:  "Jane Doe",
+			Email: "jane@example.com",
+			CC:    []User{{Name: "Her Supervisor", Email: "boss@example.com"}},
+			BCC:   []User{{Email: "audit@example.com"}},
+		},
+		Config: testConfig(),
+	}
+
+	if err := em.Send(transport); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := transport.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("recorded %d messages, want 1", len(messages))
+	}
+	raw := messages[0]
+
+	if raw.From != "monitoring@example.com" {
+		t.Errorf("From = %q, want monitoring@example.com", raw.From)
+	}
+	if raw.Template != "LATE" {
+		t.Errorf("Template = %q, want LATE", raw.Template)
+	}
+
+	rcpts := slices.Sorted(slices.Values(raw.Rcpts))
+	want := []string{"audit@example.com", "boss@example.com", "hr@example.com", "jane@example.com", "records@example.com"}
+	if !slices.Equal(rcpts, want) {
+		t.Errorf("Rcpts = %v, want %v", rcpts, want)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for header, value := range map[string]string{
+		"From":             `"Monitoring" <monitoring@example.com>`,
+		"To":               `"Jane Doe" <jane@example.com>`,
+		"Subject":          "Important Reminder for Late Interns",
+		"Importance":       "high",
+		"Content-Language": "en",
+		"Message-Id":       raw.MessageID,
+	} {
+		if got := msg.Header.Get(header); got != value {
+			t.Errorf("%s = %q, want %q", header, got, value)
+		}
+	}
+
+	cc := msg.Header.Get("Cc")
+	if !strings.Contains(cc, "hr@example.com") || !strings.Contains(cc, "boss@example.com") {
+		t.Errorf("Cc = %q, want hr@example.com and boss@example.com", cc)
+	}
+	if bcc := msg.Header.Get("Bcc"); bcc != "" {
+		t.Errorf("Bcc = %q, want it left out of the message", bcc)
+	}
+}
+
+func TestMemoryTransportErr(t *testing.T) {
+	transport := &MemoryTransport{Err: errors.New("mailbox full")}
+	em := Email{Body: Late, To: User{Email: "jane@example.com"}, Config: testConfig()}
+
+	if err := em.Send(transport); err == nil || !strings.Contains(err.Error(), "mailbox full") {
+		t.Errorf("Send = %v, want the transport's error", err)
+	}
+	if n := len(transport.Messages()); n != 0 {
+		t.Errorf("recorded %d messages, want none", n)
+	}
+}
+
+func TestFileTransport(t *testing.T) {
+	raw := RawMessage{From: "me@example.com", Rcpts: []string{"jane@example.com"}, Data: []byte("Subject: Hi\r\n\r\nHello\r\n")}
+
+	t.Run("eml", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "outbox")
+		if _, err := (FileTransport{Dir: dir}).Send(context.Background(), raw); err != nil {
+			t.Fatal(err)
+		}
+
+		files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+		if len(files) != 1 {
+			t.Fatalf("wrote %d .eml files, want 1", len(files))
+		}
+		if data, _ := os.ReadFile(files[0]); !bytes.Equal(data, raw.Data) {
+			t.Errorf("wrote %q, want %q", data, raw.Data)
+		}
+	})
+
+	t.Run("maildir", func(t *testing.T) {
+		dir := t.TempDir()
+		response, err := (FileTransport{Dir: dir, Maildir: true}).Send(context.Background(), raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files, _ := os.ReadDir(filepath.Join(dir, "new"))
+		if len(files) != 1 {
+			t.Fatalf("wrote %d files to new, want 1", len(files))
+		}
+		path := filepath.Join(dir, "new", files[0].Name())
+		if data, _ := os.ReadFile(path); !bytes.Equal(data, raw.Data) {
+			t.Errorf("wrote %q, want %q", data, raw.Data)
+		}
+		if !strings.HasSuffix(response, path) {
+			t.Errorf("response = %q, want the path %s", response, path)
+		}
+
+		for _, sub := range []string{"tmp", "cur"} {
+			if files, err := os.ReadDir(filepath.Join(dir, sub)); err != nil || len(files) != 0 {
+				t.Errorf("%s has %d files (%v), want it empty", sub, len(files), err)
+			}
+		}
+	})
+}
+
+// Appends its name to the message, and records the data it was given.
+type markSigner struct {
+	name string
+	seen *[]string
+}
+
+func (s markSigner) Sign(raw RawMessage) ([]byte, error) {
+	*s.seen = append(*s.seen, string(raw.Data))
+	return append(slices.Clone(raw.Data), s.name...), nil
+}
+
+type failSigner struct{}
+
+func (failSigner) Sign(RawMessage) ([]byte, error) {
+	return nil, errors.New("no key")
+}
+
+func TestSigningTransport(t *testing.T) {
+	memory := &MemoryTransport{}
+	seen := []string{}
+	transport := SigningTransport{
+		Transport: memory,
+		Signers:   []Signer{markSigner{"[smime]", &seen}, markSigner{"[dkim]", &seen}},
+	}
+
+	if _, err := transport.Send(context.Background(), RawMessage{Data: []byte("message")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each signer signs what the one before it gave back
+	if want := []string{"message", "message[smime]"}; !slices.Equal(seen, want) {
+		t.Errorf("signers were given %q, want %q", seen, want)
+	}
+	if got := string(memory.Messages()[0].Data); got != "message[smime][dkim]" {
+		t.Errorf("sent %q, want message[smime][dkim]", got)
+	}
+
+	transport.Signers = append([]Signer{failSigner{}}, transport.Signers...)
+	if _, err := transport.Send(context.Background(), RawMessage{Data: []byte("message")}); err == nil {
+		t.Error("a failed signature didn't stop the message")
+	}
+	if n := len(memory.Messages()); n != 1 {
+		t.Errorf("sent %d messages, want the unsigned one left unsent", n)
+	}
+}
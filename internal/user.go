@@ -2,17 +2,66 @@
 
 package email
 
-import "fmt"
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+)
 
 type User struct {
-	Name  string
-	Email string
+	Name        string
+	Email       string
+	Password    string   `json:"-"`          // Shown in the Credentials email, the default if empty; never saved in the queue
+	Attachments []string `json:",omitempty"` // Files attached only to this user's email
+	CC          []User   `json:",omitempty"` // Copied only on this user's email
+	BCC         []User   `json:",omitempty"` // Blind copied only on this user's email
+	Language    string   `json:",omitempty"` // Locale of the templates sent to the user, e.g. fil; DEFAULT_LOCALE if empty
+
+	// Every column of the user's row in the input file, by its header
+	// as written, for templates. The password column is left out.
+	Fields map[string]string `json:",omitempty"`
 }
 
 func (u User) String() string {
+	if u.Name == "" {
+		return u.Email
+	}
 	return fmt.Sprintf("%s <%s>", u.Name, u.Email)
 }
 
+// Reports whether the user has an email address. The name is optional.
 func (u User) Exists() bool {
-	return u.Name != "" && u.Email != ""
+	return u.Email != ""
+}
+
+// Parses a list of addresses separated by "," or ";", each either a
+// bare address or "Name <address>", and checks every one of them.
+func ParseUsers(list string) ([]User, error) {
+	users := []User{}
+	if strings.TrimSpace(list) == "" {
+		return users, nil
+	}
+
+	addresses, err := mail.ParseAddressList(strings.ReplaceAll(list, ";", ","))
+	if err != nil {
+		return users, fmt.Errorf("invalid address list %q", list)
+	}
+
+	for _, a := range addresses {
+		if !IsValidEmail(a.Address) {
+			return users, fmt.Errorf("invalid email %s", a.Address)
+		}
+		users = append(users, User{Name: a.Name, Email: a.Address})
+	}
+
+	return users, nil
+}
+
+// Joins the users into a comma separated list.
+func JoinUsers(users []User) string {
+	list := make([]string, len(users))
+	for i, u := range users {
+		list[i] = u.String()
+	}
+	return strings.Join(list, ", ")
 }